@@ -10,6 +10,7 @@ import (
 // serveSubApex serves requests that hit the zones fake 'dns' subdomain where our nameservers live.
 func (gw *Gateway) serveSubApex(state request.Request) (int, error) {
 	base, _ := dnsutil.TrimZone(state.Name(), state.Zone)
+	zc := gw.configFor(state.Zone)
 
 	m := new(dns.Msg)
 	m.SetReply(state.Req)
@@ -19,16 +20,16 @@ func (gw *Gateway) serveSubApex(state request.Request) (int, error) {
 	switch labels := dns.CountLabel(base); labels {
 	default:
 		m.SetRcode(m, dns.RcodeNameError)
-		m.Ns = []dns.RR{gw.soa(state)}
+		m.Ns = []dns.RR{zc.soa(state)}
 		if err := state.W.WriteMsg(m); err != nil {
 			log.Errorf("Failed to send a response: %s", err)
 		}
 		return 0, nil
 	case 2:
-		if base != gw.apex {
+		if base != zc.apex {
 			// nxdomain
 			m.SetRcode(m, dns.RcodeNameError)
-			m.Ns = []dns.RR{gw.soa(state)}
+			m.Ns = []dns.RR{zc.soa(state)}
 			if err := state.W.WriteMsg(m); err != nil {
 				log.Errorf("Failed to send a response: %s", err)
 			}
@@ -37,7 +38,7 @@ func (gw *Gateway) serveSubApex(state request.Request) (int, error) {
 
 		addr := gw.ExternalAddrFunc(state)
 		for _, rr := range addr {
-			rr.Header().Ttl = gw.ttlSOA
+			rr.Header().Ttl = zc.ttlSOA
 			rr.Header().Name = state.QName()
 			switch state.QType() {
 			case dns.TypeA:
@@ -52,7 +53,7 @@ func (gw *Gateway) serveSubApex(state request.Request) (int, error) {
 		}
 
 		if len(m.Answer) == 0 {
-			m.Ns = []dns.RR{gw.soa(state)}
+			m.Ns = []dns.RR{zc.soa(state)}
 		}
 
 		if err := state.W.WriteMsg(m); err != nil {
@@ -63,26 +64,26 @@ func (gw *Gateway) serveSubApex(state request.Request) (int, error) {
 	}
 }
 
-func (gw *Gateway) soa(state request.Request) *dns.SOA {
-	header := dns.RR_Header{Name: state.Zone, Rrtype: dns.TypeSOA, Ttl: gw.ttlSOA, Class: dns.ClassINET}
+func (zc *zoneConfig) soa(state request.Request) *dns.SOA {
+	header := dns.RR_Header{Name: state.Zone, Rrtype: dns.TypeSOA, Ttl: zc.ttlSOA, Class: dns.ClassINET}
 
 	soa := &dns.SOA{Hdr: header,
-		Mbox:    dnsutil.Join(gw.hostmaster, gw.apex, state.Zone),
-		Ns:      dnsutil.Join(gw.apex, state.Zone),
+		Mbox:    dnsutil.Join(zc.hostmaster, zc.apex, state.Zone),
+		Ns:      dnsutil.Join(zc.apex, state.Zone),
 		Serial:  12345, // Also dynamic?
 		Refresh: 7200,
 		Retry:   1800,
 		Expire:  86400,
-		Minttl:  gw.ttlSOA,
+		Minttl:  zc.ttlSOA,
 	}
 	return soa
 }
 
-func (gw *Gateway) nameservers(state request.Request) (result []dns.RR) {
-	primaryNS := gw.ns1(state)
+func (zc *zoneConfig) nameservers(state request.Request) (result []dns.RR) {
+	primaryNS := zc.ns1(state)
 	result = append(result, primaryNS)
 
-	secondaryNS := gw.ns2(state)
+	secondaryNS := zc.ns2(state)
 	if secondaryNS != nil {
 		result = append(result, secondaryNS)
 	}
@@ -90,19 +91,19 @@ func (gw *Gateway) nameservers(state request.Request) (result []dns.RR) {
 	return result
 }
 
-func (gw *Gateway) ns1(state request.Request) *dns.NS {
-	header := dns.RR_Header{Name: state.Zone, Rrtype: dns.TypeNS, Ttl: gw.ttlSOA, Class: dns.ClassINET}
-	ns := &dns.NS{Hdr: header, Ns: dnsutil.Join(gw.apex, state.Zone)}
+func (zc *zoneConfig) ns1(state request.Request) *dns.NS {
+	header := dns.RR_Header{Name: state.Zone, Rrtype: dns.TypeNS, Ttl: zc.ttlSOA, Class: dns.ClassINET}
+	ns := &dns.NS{Hdr: header, Ns: dnsutil.Join(zc.apex, state.Zone)}
 
 	return ns
 }
 
-func (gw *Gateway) ns2(state request.Request) *dns.NS {
-	if gw.secondNS == "" { // If second NS is undefined, return nothing
+func (zc *zoneConfig) ns2(state request.Request) *dns.NS {
+	if zc.secondNS == "" { // If second NS is undefined, return nothing
 		return nil
 	}
-	header := dns.RR_Header{Name: state.Zone, Rrtype: dns.TypeNS, Ttl: gw.ttlSOA, Class: dns.ClassINET}
-	ns := &dns.NS{Hdr: header, Ns: dnsutil.Join(gw.secondNS, state.Zone)}
+	header := dns.RR_Header{Name: state.Zone, Rrtype: dns.TypeNS, Ttl: zc.ttlSOA, Class: dns.ClassINET}
+	ns := &dns.NS{Hdr: header, Ns: dnsutil.Join(zc.secondNS, state.Zone)}
 
 	return ns
 }
@@ -10,28 +10,30 @@ import (
 	"github.com/coredns/coredns/request"
 
 	"github.com/miekg/dns"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 func setupEmptyLookupFuncs() {
 	if resource := lookupResource("HTTPRoute"); resource != nil {
-		resource.lookup = func(_ []string) []netip.Addr { return []netip.Addr{} }
+		resource.lookup = func(_ []string) []lookupResult { return []lookupResult{} }
 	}
 	if resource := lookupResource("TLSRoute"); resource != nil {
-		resource.lookup = func(_ []string) []netip.Addr { return []netip.Addr{} }
+		resource.lookup = func(_ []string) []lookupResult { return []lookupResult{} }
 	}
 	if resource := lookupResource("GRPCRoute"); resource != nil {
-		resource.lookup = func(_ []string) []netip.Addr { return []netip.Addr{} }
+		resource.lookup = func(_ []string) []lookupResult { return []lookupResult{} }
 	}
 	if resource := lookupResource("Ingress"); resource != nil {
-		resource.lookup = func(_ []string) []netip.Addr { return []netip.Addr{} }
+		resource.lookup = func(_ []string) []lookupResult { return []lookupResult{} }
 	}
 	if resource := lookupResource("Service"); resource != nil {
-		resource.lookup = func(_ []string) []netip.Addr { return []netip.Addr{} }
+		resource.lookup = func(_ []string) []lookupResult { return []lookupResult{} }
 	}
 }
 
 func TestDualNS(t *testing.T) {
-	ctrl := &KubeController{hasSynced: true}
+	ctrl := newSyncedController()
 	gw := newGateway()
 	gw.Zones = []string{"example.com."}
 	gw.Next = test.NextHandler(dns.RcodeSuccess, nil)
@@ -64,6 +66,75 @@ func TestDualNS(t *testing.T) {
 	}
 }
 
+func TestSelfAddressDualStack(t *testing.T) {
+	gw := newGateway()
+	gw.Zones = []string{"example.com."}
+	gw.Next = test.NextHandler(dns.RcodeSuccess, nil)
+	gw.Controller = newSyncedController()
+	gw.ExternalAddrFunc = gw.SelfAddress
+	gw.secondNS = "dns2.kube-system"
+
+	// the load balancer of dns2 has both families but the Service is IPv4 only
+	services := map[string]*core.Service{
+		"dns1.kube-system": testNameserverService("dns1", core.IPv4Protocol, core.IPv6Protocol),
+		"dns2.kube-system": testNameserverService("dns2", core.IPv4Protocol),
+	}
+	addrs := map[string][]netip.Addr{
+		"dns1.kube-system": {netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("2001:db8::1")},
+		"dns2.kube-system": {netip.MustParseAddr("192.0.2.2"), netip.MustParseAddr("2001:db8::2")},
+	}
+	clearLookupFuncs()
+	lookupResource("Service").setLookup("", gw, func(keys []string) (results []lookupResult) {
+		for _, key := range keys {
+			if service, ok := services[key]; ok {
+				results = append(results, lookupResult{kind: "Service", object: service, addrs: addrs[key]})
+			}
+		}
+		return results
+	}, func() []string { return []string{"dns1.kube-system", "dns2.kube-system"} })
+
+	tests := []test.Case{
+		{
+			Qname: "example.com.", Qtype: dns.TypeNS,
+			Rcode: dns.RcodeSuccess,
+			Answer: []dns.RR{
+				test.NS("example.com.	60	IN	NS	dns1.kube-system.example.com."),
+				test.NS("example.com.	60	IN	NS	dns2.kube-system.example.com."),
+			},
+			Extra: []dns.RR{
+				test.A("dns1.kube-system.example.com.	60	IN	A	192.0.2.1"),
+				test.AAAA("dns1.kube-system.example.com.	60	IN	AAAA	2001:db8::1"),
+				test.A("dns2.kube-system.example.com.	60	IN	A	192.0.2.2"),
+			},
+		},
+		{
+			Qname: "dns1.kube-system.example.com.", Qtype: dns.TypeAAAA,
+			Rcode: dns.RcodeSuccess,
+			Answer: []dns.RR{
+				test.AAAA("dns1.kube-system.example.com.	60	IN	AAAA	2001:db8::1"),
+			},
+		},
+	}
+
+	for i, tc := range tests {
+		w := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := gw.ServeDNS(context.TODO(), w, tc.Msg()); err != nil {
+			t.Errorf("Test %d: expected no error, got %v", i, err)
+			continue
+		}
+		if err := test.SortAndCheck(w.Msg, tc); err != nil {
+			t.Errorf("Test %d: %v", i, err)
+		}
+	}
+}
+
+func testNameserverService(name string, families ...core.IPFamily) *core.Service {
+	return &core.Service{
+		ObjectMeta: meta.ObjectMeta{Name: name, Namespace: "kube-system"},
+		Spec:       core.ServiceSpec{Type: core.ServiceTypeLoadBalancer, IPFamilies: families},
+	}
+}
+
 var testsDualNS = []test.Case{
 	{
 		Qname: "example.com.", Qtype: dns.TypeSOA,
@@ -13,7 +13,7 @@ import (
 
 func TestApex(t *testing.T) {
 
-	ctrl := &KubeController{hasSynced: true}
+	ctrl := newSyncedController()
 	gw := newGateway()
 	gw.Zones = []string{"example.com."}
 	gw.Next = test.NextHandler(dns.RcodeSuccess, nil)
@@ -0,0 +1,217 @@
+package gateway
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// clusterConfig is a cluster watched by the plugin. The name tells the clusters apart when
+// several are configured, it is empty for a single cluster.
+type clusterConfig struct {
+	name    string
+	file    string
+	context string
+}
+
+// kubeconfigClusters returns the clusters of a kubeconfig directive. A directory holds one
+// kubeconfig per cluster, named after the file. Otherwise the cluster is named after the context
+// if one is given, or after the file.
+func kubeconfigClusters(path, context string) ([]clusterConfig, error) {
+	info, err := os.Stat(path)
+	if err != nil || !info.IsDir() {
+		// missing files are reported when the client is built, as they may be mounted later
+		name := context
+		if name == "" {
+			name = clusterName(path)
+		}
+		return []clusterConfig{{name: name, file: path, context: context}}, nil
+	}
+
+	if context != "" {
+		return nil, fmt.Errorf("a context can't be set for a directory of kubeconfigs: %s", path)
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var clusters []clusterConfig
+	for _, entry := range entries {
+		// hidden entries include the data links of mounted secrets and config maps
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		file := filepath.Join(path, entry.Name())
+		if info, err := os.Stat(file); err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		clusters = append(clusters, clusterConfig{name: clusterName(file), file: file})
+	}
+	if len(clusters) == 0 {
+		return nil, fmt.Errorf("no kubeconfig found in %s", path)
+	}
+	return clusters, nil
+}
+
+func clusterName(file string) string {
+	base := filepath.Base(file)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// clusterMode defines how the objects of several clusters claiming the same hostname are combined
+type clusterMode int
+
+const (
+	clusterUnion clusterMode = iota
+	clusterFailover
+)
+
+type clusterPolicy struct {
+	mode     clusterMode
+	priority []string
+}
+
+func parseClusterPolicy(args []string) (clusterPolicy, error) {
+	if len(args) == 0 {
+		return clusterPolicy{}, fmt.Errorf("missing cluster policy")
+	}
+
+	switch args[0] {
+	case "union":
+		if len(args) > 1 {
+			return clusterPolicy{}, fmt.Errorf("unexpected arguments for cluster policy 'union': %v", args[1:])
+		}
+		return clusterPolicy{mode: clusterUnion}, nil
+	case "failover":
+		return clusterPolicy{mode: clusterFailover, priority: args[1:]}, nil
+	}
+
+	return clusterPolicy{}, fmt.Errorf("unknown cluster policy '%s'", args[0])
+}
+
+// validate checks that the clusters in the priority list are configured
+func (p clusterPolicy) validate(clusters []clusterConfig) error {
+	for _, name := range p.priority {
+		if !slices.ContainsFunc(clusters, func(c clusterConfig) bool { return c.name == name }) {
+			return fmt.Errorf("cluster policy refers to unknown cluster '%s'", name)
+		}
+	}
+	return nil
+}
+
+// pick returns the results published for a hostname. With failover, only the results of the
+// first healthy cluster in the priority list claiming the hostname are kept, falling back to
+// unhealthy clusters if no healthy one claims it. Unlisted clusters come last, by name.
+func (p clusterPolicy) pick(results []lookupResult, healthy func(cluster string) bool) []lookupResult {
+	if p.mode != clusterFailover {
+		return results
+	}
+
+	var claiming []string
+	for _, result := range results {
+		if result.claims() && !slices.Contains(claiming, result.cluster) {
+			claiming = append(claiming, result.cluster)
+		}
+	}
+	if len(claiming) < 2 {
+		return results
+	}
+
+	sort.Slice(claiming, func(i, j int) bool {
+		if ri, rj := p.rank(claiming[i]), p.rank(claiming[j]); ri != rj {
+			return ri < rj
+		}
+		return claiming[i] < claiming[j]
+	})
+	picked := claiming[0]
+	for _, cluster := range claiming {
+		if healthy(cluster) {
+			picked = cluster
+			break
+		}
+	}
+
+	var kept []lookupResult
+	for _, result := range results {
+		if result.cluster == picked {
+			kept = append(kept, result)
+		}
+	}
+	return kept
+}
+
+func (p clusterPolicy) rank(cluster string) int {
+	for i, name := range p.priority {
+		if name == cluster {
+			return i
+		}
+	}
+	return len(p.priority)
+}
+
+// clusterConfigs returns the configured clusters, the one the plugin runs in by default
+func (gw *Gateway) clusterConfigs() []clusterConfig {
+	if len(gw.clusters) == 0 {
+		return []clusterConfig{{}}
+	}
+	return gw.clusters
+}
+
+// controllers returns the controllers of all watched clusters
+func (gw *Gateway) controllers() []*KubeController {
+	if len(gw.clusterControllers) > 0 {
+		return gw.clusterControllers
+	}
+	if gw.Controller != nil {
+		return []*KubeController{gw.Controller}
+	}
+	return nil
+}
+
+// controllerFor returns the controller of a cluster, or nil if it isn't watched
+func (gw *Gateway) controllerFor(cluster string) *KubeController {
+	for _, ctrl := range gw.controllers() {
+		if ctrl.cluster == cluster {
+			return ctrl
+		}
+	}
+	return nil
+}
+
+// hasSynced returns true once a cluster is synced and each of the others is either synced or
+// failing, so that an unreachable cluster doesn't keep the others from being served
+func (gw *Gateway) hasSynced() bool {
+	var synced bool
+	for _, ctrl := range gw.controllers() {
+		switch {
+		case ctrl.HasSynced():
+			synced = true
+		case len(ctrl.Failing()) == 0:
+			return false
+		}
+	}
+	return synced
+}
+
+// unsynced returns the resources that aren't synced yet, prefixed with their cluster if named
+func (gw *Gateway) unsynced() (kinds []string) {
+	for _, ctrl := range gw.controllers() {
+		for _, kind := range ctrl.Unsynced() {
+			if ctrl.cluster != "" {
+				kind = ctrl.cluster + "/" + kind
+			}
+			kinds = append(kinds, kind)
+		}
+	}
+	return kinds
+}
+
+// clusterHealthy returns true if all resources of a cluster are synced and none is failing
+func (gw *Gateway) clusterHealthy(cluster string) bool {
+	ctrl := gw.controllerFor(cluster)
+	return ctrl != nil && ctrl.HasSynced() && len(ctrl.Failing()) == 0
+}
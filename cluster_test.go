@@ -0,0 +1,162 @@
+package gateway
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKubeconfigClusters(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"eu.yaml", "us", ".hidden"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// mounted secrets link their files to a hidden data directory
+	if err := os.Mkdir(filepath.Join(dir, "..data"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path, context string
+		expected      string
+		shouldErr     bool
+	}{
+		{filepath.Join(dir, "eu.yaml"), "", "[eu]", false},
+		{filepath.Join(dir, "eu.yaml"), "prod", "[prod]", false},
+		{dir, "", "[eu us]", false},
+		{dir, "prod", "", true},
+		{t.TempDir(), "", "", true},
+	}
+
+	for i, test := range tests {
+		clusters, err := kubeconfigClusters(test.path, test.context)
+		if test.shouldErr {
+			if err == nil {
+				t.Errorf("Test %d: expected an error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: expected no error, got %s", i, err)
+			continue
+		}
+		var names []string
+		for _, cluster := range clusters {
+			names = append(names, cluster.name)
+		}
+		if fmt.Sprint(names) != test.expected {
+			t.Errorf("Test %d: expected clusters %s, got %v", i, test.expected, names)
+		}
+	}
+}
+
+func TestClusterPolicy(t *testing.T) {
+	eu := lookupResult{kind: "Ingress", cluster: "eu", addrs: []netip.Addr{netip.MustParseAddr("192.0.2.1")}}
+	us := lookupResult{kind: "HTTPRoute", cluster: "us", addrs: []netip.Addr{netip.MustParseAddr("192.0.2.2")}}
+	ap := lookupResult{kind: "Ingress", cluster: "ap", addrs: []netip.Addr{netip.MustParseAddr("192.0.2.3")}}
+	empty := lookupResult{kind: "Service", cluster: "eu"}
+	results := []lookupResult{eu, empty, us, ap}
+
+	healthy := func(string) bool { return true }
+	unhealthy := func(cluster string) func(string) bool {
+		return func(c string) bool { return c != cluster }
+	}
+
+	tests := []struct {
+		policy   clusterPolicy
+		healthy  func(string) bool
+		expected string
+	}{
+		{clusterPolicy{mode: clusterUnion}, healthy, "[eu eu us ap]"},
+		{clusterPolicy{mode: clusterFailover, priority: []string{"us", "eu"}}, healthy, "[us]"},
+		// the next cluster in the priority list takes over from an unhealthy one
+		{clusterPolicy{mode: clusterFailover, priority: []string{"us", "eu"}}, unhealthy("us"), "[eu eu]"},
+		// unlisted clusters come last, by name
+		{clusterPolicy{mode: clusterFailover}, healthy, "[ap]"},
+		{clusterPolicy{mode: clusterFailover, priority: []string{"eu"}}, unhealthy("eu"), "[ap]"},
+		// without any healthy cluster, the first one is kept
+		{clusterPolicy{mode: clusterFailover, priority: []string{"us"}}, func(string) bool { return false }, "[us]"},
+	}
+
+	for i, test := range tests {
+		var clusters []string
+		for _, result := range test.policy.pick(results, test.healthy) {
+			clusters = append(clusters, result.cluster)
+		}
+		if fmt.Sprint(clusters) != test.expected {
+			t.Errorf("Test %d: expected results from %s, got %v", i, test.expected, clusters)
+		}
+	}
+}
+
+func TestMultiCluster(t *testing.T) {
+	t.Cleanup(setupLookupFuncs)
+	clearLookupFuncs()
+
+	gw := newGateway()
+	gw.Zones = []string{"example.com."}
+	gw.conflict = conflictPolicy{mode: conflictOldest}
+	eu, us := newSyncedController(), newSyncedController()
+	eu.cluster, us.cluster = "eu", "us"
+	gw.clusterControllers = []*KubeController{eu, us}
+
+	clusterLookup := func(addr string) lookupFunc {
+		return func([]string) []lookupResult {
+			return []lookupResult{{kind: "Ingress", addrs: []netip.Addr{netip.MustParseAddr(addr)}}}
+		}
+	}
+	keys := func() []string { return []string{"app.example.com"} }
+	ingress := lookupResource("Ingress")
+	ingress.setLookup("eu", eu, clusterLookup("192.0.2.1"), keys)
+	ingress.setLookup("us", us, clusterLookup("192.0.2.2"), keys)
+
+	if found := ingress.listKeys(); len(found) != 1 {
+		t.Errorf("Expected the keys of both clusters to be merged, got %v", found)
+	}
+
+	// objects of different clusters aren't in conflict and are published together
+	ans := gw.computeAnswer("app.example.com.")
+	if ans == nil || fmt.Sprint(ans.ipv4) != "[192.0.2.1 192.0.2.2]" {
+		t.Fatalf("Expected the addresses of both clusters, got %+v", ans)
+	}
+	if len(gw.conflicts.seen) != 0 {
+		t.Errorf("Expected no conflicts to be reported, got %v", gw.conflicts.seen)
+	}
+
+	gw.clusterPolicy = clusterPolicy{mode: clusterFailover, priority: []string{"us", "eu"}}
+	if ans := gw.computeAnswer("app.example.com."); ans == nil || fmt.Sprint(ans.ipv4) != "[192.0.2.2]" {
+		t.Errorf("Expected the addresses of the preferred cluster, got %+v", ans)
+	}
+	us.setFailing("Ingress", fmt.Errorf("connection refused"))
+	if ans := gw.computeAnswer("app.example.com."); ans == nil || fmt.Sprint(ans.ipv4) != "[192.0.2.1]" {
+		t.Errorf("Expected the addresses of the other cluster while the preferred one fails, got %+v", ans)
+	}
+	if !gw.hasSynced() || !gw.Ready() {
+		t.Errorf("Expected the plugin to be synced and ready while a cluster is healthy")
+	}
+
+	// a cluster that can't be synced doesn't hold back the others once it's failing
+	us.synced.Store(false)
+	us.setFailing("Ingress", nil)
+	if gw.hasSynced() {
+		t.Errorf("Expected the plugin to wait for a cluster that is syncing")
+	}
+	us.setFailing("Ingress", fmt.Errorf("not synced"))
+	if !gw.hasSynced() {
+		t.Errorf("Expected the plugin to be synced once the other cluster is failing")
+	}
+	us.setFailing("Ingress", nil)
+
+	ingress.clearLookup("us", eu)
+	if found := ingress.find(nil); len(found) != 2 {
+		t.Errorf("Expected the lookup of a cluster to only be cleared by its owner, got %v", found)
+	}
+	ingress.clearLookup("us", us)
+	if found := ingress.find(nil); len(found) != 1 || found[0].cluster != "eu" {
+		t.Errorf("Expected only the remaining cluster to be looked up, got %v", found)
+	}
+}
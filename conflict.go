@@ -0,0 +1,295 @@
+package gateway
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	core "k8s.io/api/core/v1"
+)
+
+// conflictMode defines how a hostname claimed by several objects of the same kind is resolved
+type conflictMode int
+
+const (
+	conflictMerge conflictMode = iota
+	conflictOldest
+	conflictNamespace
+)
+
+const hostnameConflictReason = "HostnameConflict"
+
+type conflictPolicy struct {
+	mode       conflictMode
+	namespaces []string
+}
+
+func parseConflictPolicy(args []string) (conflictPolicy, error) {
+	if len(args) == 0 {
+		return conflictPolicy{}, fmt.Errorf("missing conflict policy")
+	}
+
+	switch args[0] {
+	case "merge":
+		if len(args) > 1 {
+			return conflictPolicy{}, fmt.Errorf("unexpected arguments for conflict policy 'merge': %v", args[1:])
+		}
+		return conflictPolicy{mode: conflictMerge}, nil
+	case "oldest":
+		if len(args) > 1 {
+			return conflictPolicy{}, fmt.Errorf("unexpected arguments for conflict policy 'oldest': %v", args[1:])
+		}
+		return conflictPolicy{mode: conflictOldest}, nil
+	case "namespace":
+		if len(args) == 1 {
+			return conflictPolicy{}, fmt.Errorf("conflict policy 'namespace' requires at least one namespace")
+		}
+		return conflictPolicy{mode: conflictNamespace, namespaces: args[1:]}, nil
+	}
+
+	return conflictPolicy{}, fmt.Errorf("unknown conflict policy '%s'", args[0])
+}
+
+// pick splits objects of the same kind into the ones that get published and the ones that lose
+func (p conflictPolicy) pick(candidates []lookupResult) (winners, losers []lookupResult) {
+	if len(candidates) < 2 || p.mode == conflictMerge {
+		return candidates, nil
+	}
+
+	sorted := make([]lookupResult, len(candidates))
+	copy(sorted, candidates)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return p.less(sorted[i], sorted[j])
+	})
+
+	return sorted[:1], sorted[1:]
+}
+
+func (p conflictPolicy) less(a, b lookupResult) bool {
+	if p.mode == conflictNamespace {
+		if ra, rb := p.rank(a.namespace()), p.rank(b.namespace()); ra != rb {
+			return ra < rb
+		}
+	}
+	if ta, tb := a.created(), b.created(); !ta.Equal(tb) {
+		return ta.Before(tb)
+	}
+	// objects created within the same second are ordered by their namespace/name
+	return a.String() < b.String()
+}
+
+// rank returns the position of a namespace in the priority list, unlisted namespaces come last
+func (p conflictPolicy) rank(ns string) int {
+	for i, n := range p.namespaces {
+		if n == ns {
+			return i
+		}
+	}
+	return len(p.namespaces)
+}
+
+// resolveConflicts picks the objects whose addresses are published for a hostname.
+// Resources are tried in order and the first kind with any addresses wins, unless merge
+// is set in which case all kinds are kept. Objects of the same kind are then filtered
+// according to the configured conflict policy. Conflicts are resolved within each cluster,
+// the objects picked in every cluster are published together.
+func (gw *Gateway) resolveConflicts(hostname string, results []lookupResult, merge bool) []lookupResult {
+	var claims []lookupResult
+	var clusters []string
+	for _, result := range results {
+		if !result.claims() {
+			continue
+		}
+		if !slices.Contains(clusters, result.cluster) {
+			clusters = append(clusters, result.cluster)
+		}
+		claims = append(claims, result)
+	}
+
+	if len(claims) < 2 {
+		gw.conflicts.forget(hostname)
+		return claims
+	}
+
+	var winners, losers []lookupResult
+	for _, cluster := range clusters {
+		var clusterClaims []lookupResult
+		for _, claim := range claims {
+			if claim.cluster == cluster {
+				clusterClaims = append(clusterClaims, claim)
+			}
+		}
+		picked, lost := gw.pickClaims(clusterClaims, merge)
+		winners = append(winners, picked...)
+		losers = append(losers, lost...)
+	}
+	if len(losers) == 0 && len(clusters) == len(winners) {
+		// a single object in each cluster isn't a conflict
+		gw.conflicts.forget(hostname)
+		return winners
+	}
+	gw.conflicts.report(gw.controllerFor, plugin.Zones(gw.Zones).Matches(hostname+"."), hostname, winners, losers)
+
+	return winners
+}
+
+// pickClaims resolves the claims on a hostname of a single cluster
+func (gw *Gateway) pickClaims(claims []lookupResult, merge bool) (winners, losers []lookupResult) {
+	var kinds []string
+	for _, claim := range claims {
+		if !slices.Contains(kinds, claim.kind) {
+			kinds = append(kinds, claim.kind)
+		}
+	}
+
+	for i, kind := range kinds {
+		var candidates []lookupResult
+		for _, claim := range claims {
+			if claim.kind == kind {
+				candidates = append(candidates, claim)
+			}
+		}
+
+		if i > 0 && !merge {
+			losers = append(losers, candidates...)
+			continue
+		}
+
+		picked, lost := gw.conflict.pick(candidates)
+		winners = append(winners, picked...)
+		losers = append(losers, lost...)
+	}
+	return winners, losers
+}
+
+// conflictTracker remembers the conflicts that have already been reported, so that
+// events and metrics are only emitted when the set of objects claiming a name changes
+type conflictTracker struct {
+	sync.Mutex
+	seen map[string]reportedConflict
+}
+
+// reportedConflict is the last conflict reported for a hostname
+type reportedConflict struct {
+	zone        string
+	fingerprint string
+}
+
+func newConflictTracker() *conflictTracker {
+	return &conflictTracker{seen: make(map[string]reportedConflict)}
+}
+
+// setGauge counts the hostnames in conflict in a zone. It must be called with the lock held.
+func (t *conflictTracker) setGauge(zone string) {
+	var count int
+	for _, conflict := range t.seen {
+		if conflict.zone == zone {
+			count++
+		}
+	}
+	hostnameConflicts.WithLabelValues(zone).Set(float64(count))
+}
+
+func (t *conflictTracker) report(controllerFor func(cluster string) *KubeController, zone, hostname string, winners, losers []lookupResult) {
+	conflict := reportedConflict{zone: zone, fingerprint: describeResults(winners) + " > " + describeResults(losers)}
+
+	t.Lock()
+	if t.seen[hostname] == conflict {
+		t.Unlock()
+		return
+	}
+	t.seen[hostname] = conflict
+	t.setGauge(zone)
+	t.Unlock()
+
+	if len(losers) == 0 {
+		log.Infof("Hostname %s is claimed by multiple objects, merging %s", hostname, describeResults(winners))
+		return
+	}
+	log.Infof("Hostname %s is claimed by multiple objects, publishing %s over %s", hostname, describeResults(winners), describeResults(losers))
+
+	for _, loser := range losers {
+		// events are recorded in the cluster of the object
+		ctrl := controllerFor(loser.cluster)
+		if loser.object == nil || ctrl == nil || ctrl.recorder == nil {
+			continue
+		}
+		ctrl.recorder.Eventf(loser.object, core.EventTypeWarning, hostnameConflictReason,
+			"Hostname %s is also claimed by %s, addresses of this object are not published", hostname, describeResults(winners))
+	}
+}
+
+func (t *conflictTracker) forget(hostname string) {
+	t.Lock()
+	if conflict, ok := t.seen[hostname]; ok {
+		delete(t.seen, hostname)
+		t.setGauge(conflict.zone)
+	}
+	t.Unlock()
+}
+
+// prune forgets the conflicts of hostnames that are no longer indexed
+func (t *conflictTracker) prune(fqdns map[string]struct{}) {
+	t.Lock()
+	pruned := make(map[string]struct{})
+	for hostname, conflict := range t.seen {
+		if _, ok := fqdns[hostname+"."]; !ok {
+			delete(t.seen, hostname)
+			pruned[conflict.zone] = struct{}{}
+		}
+	}
+	for zone := range pruned {
+		t.setGauge(zone)
+	}
+	t.Unlock()
+}
+
+func describeResults(results []lookupResult) string {
+	var names []string
+	for _, result := range results {
+		names = append(names, result.String())
+	}
+	return strings.Join(names, ", ")
+}
+
+// claims returns true if the object has addresses or a CNAME target in any view
+func (r lookupResult) claims() bool {
+	return len(r.addrs) > 0 || r.cname != "" || len(r.internal) > 0 || r.internalCNAME != ""
+}
+
+func (r lookupResult) namespace() string {
+	if r.object == nil {
+		return ""
+	}
+	return r.object.GetNamespace()
+}
+
+func (r lookupResult) created() time.Time {
+	if r.object == nil {
+		return time.Time{}
+	}
+	return r.object.GetCreationTimestamp().Time
+}
+
+// ttl returns the TTL requested by the object annotations
+func (r lookupResult) ttl() (uint32, bool) {
+	if r.object == nil {
+		return 0, false
+	}
+	return objectTTL(r.object)
+}
+
+func (r lookupResult) String() string {
+	s := r.kind
+	if r.object != nil {
+		s = fmt.Sprintf("%s %s/%s", r.kind, r.object.GetNamespace(), r.object.GetName())
+	}
+	if r.cluster != "" {
+		s += " in " + r.cluster
+	}
+	return s
+}
@@ -0,0 +1,142 @@
+package gateway
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	networking "k8s.io/api/networking/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestConflictPolicyParse(t *testing.T) {
+	tests := []struct {
+		args      []string
+		shouldErr bool
+		expected  conflictMode
+	}{
+		{[]string{"merge"}, false, conflictMerge},
+		{[]string{"oldest"}, false, conflictOldest},
+		{[]string{"namespace", "prod", "staging"}, false, conflictNamespace},
+		{[]string{}, true, conflictMerge},
+		{[]string{"namespace"}, true, conflictMerge},
+		{[]string{"oldest", "prod"}, true, conflictMerge},
+		{[]string{"newest"}, true, conflictMerge},
+	}
+
+	for i, test := range tests {
+		policy, err := parseConflictPolicy(test.args)
+		if test.shouldErr != (err != nil) {
+			t.Errorf("Test %d: expected error %t, got %v", i, test.shouldErr, err)
+			continue
+		}
+		if policy.mode != test.expected {
+			t.Errorf("Test %d: expected mode %d, got %d", i, test.expected, policy.mode)
+		}
+	}
+}
+
+func TestResolveConflicts(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+
+	gw := newGateway()
+	gw.Zones = []string{"example.com."}
+	gw.Controller = newSyncedController()
+	gw.Controller.recorder = recorder
+
+	old := testConflictResult("Ingress", "team-b", "old", time.Hour, "192.0.2.1")
+	young := testConflictResult("Ingress", "team-a", "young", time.Minute, "192.0.2.2")
+	service := testConflictResult("Service", "team-c", "svc", 2*time.Hour, "192.0.2.3")
+	empty := testConflictResult("HTTPRoute", "team-d", "route", 3*time.Hour)
+
+	tests := []struct {
+		policy   conflictPolicy
+		expected []string
+	}{
+		{conflictPolicy{mode: conflictMerge}, []string{"192.0.2.2", "192.0.2.1"}},
+		{conflictPolicy{mode: conflictOldest}, []string{"192.0.2.1"}},
+		{conflictPolicy{mode: conflictNamespace, namespaces: []string{"team-a"}}, []string{"192.0.2.2"}},
+		{conflictPolicy{mode: conflictNamespace, namespaces: []string{"team-x"}}, []string{"192.0.2.1"}},
+	}
+
+	for i, test := range tests {
+		gw.conflict = test.policy
+		winners := gw.resolveConflicts("app.example.com", []lookupResult{empty, young, old, service}, false)
+
+		var addrs []string
+		for _, winner := range winners {
+			for _, addr := range winner.addrs {
+				addrs = append(addrs, addr.String())
+			}
+		}
+		if len(addrs) != len(test.expected) {
+			t.Errorf("Test %d: expected addresses %v, got %v", i, test.expected, addrs)
+			continue
+		}
+		for j := range addrs {
+			if addrs[j] != test.expected[j] {
+				t.Errorf("Test %d: expected addresses %v, got %v", i, test.expected, addrs)
+				break
+			}
+		}
+	}
+
+	// the same conflict must only be reported once
+	events := len(recorder.Events)
+	gw.resolveConflicts("app.example.com", []lookupResult{empty, young, old, service}, false)
+	if len(recorder.Events) != events {
+		t.Errorf("Expected no new events for an already reported conflict, got %d", len(recorder.Events)-events)
+	}
+
+	gw.conflict = conflictPolicy{mode: conflictOldest}
+	if winners := gw.resolveConflicts("app.example.com", []lookupResult{empty, young, old, service}, true); len(winners) != 2 || winners[1].kind != "Service" {
+		t.Errorf("Expected the oldest Ingress and the Service to be merged, got %v", winners)
+	}
+
+	if winners := gw.resolveConflicts("single.example.com", []lookupResult{old}, false); len(winners) != 1 {
+		t.Errorf("Expected a single claim to win, got %v", winners)
+	}
+
+	// the gauge follows the hostnames in conflict, not the number of changes
+	gw.resolveConflicts("web.example.com", []lookupResult{young, old}, false)
+	gw.conflict = conflictPolicy{mode: conflictNamespace, namespaces: []string{"team-a"}}
+	gw.resolveConflicts("web.example.com", []lookupResult{young, old}, false)
+	if value := testutil.ToFloat64(hostnameConflicts.WithLabelValues("example.com.")); value != 2 {
+		t.Errorf("Expected 2 hostnames in conflict, got %v", value)
+	}
+	gw.resolveConflicts("app.example.com", []lookupResult{old}, false)
+	if value := testutil.ToFloat64(hostnameConflicts.WithLabelValues("example.com.")); value != 1 {
+		t.Errorf("Expected a resolved conflict to be forgotten, got %v", value)
+	}
+
+	// instances serving other zones don't overwrite the gauge
+	other := newGateway()
+	other.Zones = []string{"example.org."}
+	other.Controller = gw.Controller
+	other.resolveConflicts("web.example.org", []lookupResult{young, old}, false)
+	if value := testutil.ToFloat64(hostnameConflicts.WithLabelValues("example.com.")); value != 1 {
+		t.Errorf("Expected the conflicts of example.com to be kept, got %v", value)
+	}
+	if value := testutil.ToFloat64(hostnameConflicts.WithLabelValues("example.org.")); value != 1 {
+		t.Errorf("Expected 1 hostname in conflict in example.org, got %v", value)
+	}
+}
+
+func testConflictResult(kind, namespace, name string, age time.Duration, addrs ...string) lookupResult {
+	result := lookupResult{
+		kind: kind,
+		object: &networking.Ingress{
+			ObjectMeta: meta.ObjectMeta{
+				Name:              name,
+				Namespace:         namespace,
+				CreationTimestamp: meta.NewTime(time.Now().Add(-age)),
+			},
+		},
+	}
+	for _, addr := range addrs {
+		result.addrs = append(result.addrs, netip.MustParseAddr(addr))
+	}
+	return result
+}
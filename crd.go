@@ -0,0 +1,199 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// crdCheckInterval is how often the cluster is checked for CRDs being installed or removed
+	crdCheckInterval = 30 * time.Second
+	// crdSyncTimeout bounds how long the informers of a group may take to sync before they're retried
+	crdSyncTimeout = time.Minute
+)
+
+// newCRDBackoff returns the delays between retries of failed checks, capped to the check interval
+func newCRDBackoff() wait.Backoff {
+	return wait.Backoff{
+		Duration: time.Second,
+		Factor:   2,
+		Jitter:   0.1,
+		Steps:    math.MaxInt32,
+		Cap:      crdCheckInterval,
+	}
+}
+
+// crdResource is a custom resource that is only watched while its CRD is installed
+type crdResource struct {
+	kind  string
+	group string
+	// probe lists the resource, which fails if the CRD isn't installed or access is forbidden
+	probe func(ctx context.Context) error
+}
+
+// crdGroup is a set of informers for custom resources that are started and stopped together
+type crdGroup struct {
+	cluster   string
+	resources []crdResource
+	build     func(ctx context.Context, kinds []string) ([]cache.SharedIndexInformer, []lookupBinding)
+
+	// state of the running informers, only accessed by the controller loop
+	kinds    []string
+	stopCh   chan struct{}
+	bindings []lookupBinding
+}
+
+// lookupBinding is the lookup a resource uses while the informer behind it is running
+type lookupBinding struct {
+	resource *resourceWithIndex
+	lookup   lookupFunc
+	keys     func() []string
+	informer cache.SharedIndexInformer
+}
+
+// syncCRDs starts the informers of newly installed CRDs and stops the ones whose CRDs were removed.
+// A group is restarted whenever the set of its installed kinds changes, its previous informers keep
+// answering until the new ones have synced. It returns false if any check or sync failed, in which
+// case the affected resources are reported as failing.
+func (ctrl *KubeController) syncCRDs(ctx context.Context) bool {
+	ok := true
+	for _, group := range ctrl.crdGroups {
+		kinds, failed := installedKinds(ctx, group.resources)
+		for _, resource := range group.resources {
+			ctrl.setFailing(resource.kind, failed[resource.kind])
+		}
+		if len(failed) > 0 {
+			// the running informers are left alone until the checks succeed again
+			ok = false
+			continue
+		}
+		if slices.Equal(kinds, group.kinds) {
+			continue
+		}
+
+		if len(kinds) == 0 {
+			log.Infof("Stopping informers for %v", group.kinds)
+			ctrl.unregisterBindings(group.bindings)
+			group.stop()
+			group.kinds = nil
+		} else {
+			log.Infof("Starting informers for %v", kinds)
+			previous := group.bindings
+			if err := group.start(ctx, kinds); err != nil {
+				// the previous informers are kept, the change is retried with the next check
+				log.Warningf("Failed to start informers for %v: %s", kinds, err)
+				for _, kind := range kinds {
+					ctrl.setFailing(kind, err)
+				}
+				ok = false
+				continue
+			}
+			group.kinds = kinds
+			ctrl.unregisterBindings(previous)
+			for _, binding := range group.bindings {
+				ctrl.register(binding.resource.name, binding.informer)
+			}
+		}
+
+		if ctrl.onChange != nil {
+			ctrl.onChange(nil)
+		}
+	}
+	return ok
+}
+
+// stopCRDs stops the informers of all custom resources
+func (ctrl *KubeController) stopCRDs() {
+	for _, group := range ctrl.crdGroups {
+		ctrl.unregisterBindings(group.bindings)
+		group.stop()
+		group.kinds = nil
+	}
+}
+
+func (ctrl *KubeController) unregisterBindings(bindings []lookupBinding) {
+	for _, binding := range bindings {
+		ctrl.unregister(binding.resource.name)
+	}
+}
+
+// start runs the informers of kinds, then swaps their lookups for the ones of the running informers
+// and stops those. The running informers are left alone if the new ones fail to sync.
+func (g *crdGroup) start(ctx context.Context, kinds []string) error {
+	informers, bindings := g.build(ctx, kinds)
+
+	stopCh := make(chan struct{})
+	var synced []cache.InformerSynced
+	for _, informer := range informers {
+		go informer.Run(stopCh)
+		synced = append(synced, informer.HasSynced)
+	}
+
+	timeout, cancel := context.WithTimeout(ctx, crdSyncTimeout)
+	defer cancel()
+	if !cache.WaitForCacheSync(timeout.Done(), synced...) {
+		close(stopCh)
+		return fmt.Errorf("informers did not sync within %s", crdSyncTimeout)
+	}
+
+	// lookups are only swapped in once the caches are filled
+	bound := make(map[*resourceWithIndex]bool, len(bindings))
+	for _, binding := range bindings {
+		binding.resource.setLookup(g.cluster, g, binding.lookup, binding.keys)
+		bound[binding.resource] = true
+	}
+	for _, binding := range g.bindings {
+		if !bound[binding.resource] {
+			binding.resource.clearLookup(g.cluster, g)
+		}
+	}
+	if g.stopCh != nil {
+		close(g.stopCh)
+	}
+	g.stopCh, g.bindings = stopCh, bindings
+	return nil
+}
+
+func (g *crdGroup) stop() {
+	for _, binding := range g.bindings {
+		binding.resource.clearLookup(g.cluster, g)
+	}
+	g.bindings = nil
+
+	if g.stopCh != nil {
+		close(g.stopCh)
+		g.stopCh = nil
+	}
+}
+
+// installedKinds returns the kinds whose CRDs are installed and readable, and the kinds that
+// couldn't be checked for another reason, e.g. the API server being unavailable
+func installedKinds(ctx context.Context, resources []crdResource) (kinds []string, failed map[string]error) {
+	for _, resource := range resources {
+		err := resource.probe(ctx)
+		switch {
+		case err == nil:
+			kinds = append(kinds, resource.kind)
+		case meta.IsNoMatchError(err) || runtime.IsNotRegisteredError(err) || apierrors.IsNotFound(err):
+			log.Debugf("%s CRDs are not found. Not syncing %s resources.", resource.kind, resource.kind)
+		case apierrors.IsForbidden(err):
+			log.Debugf("access to `%s` is forbidden, please check RBAC. Not syncing %s resources.", resource.group, resource.kind)
+		default:
+			log.Warningf("Failed to check %s CRDs: %s", resource.kind, err)
+			if failed == nil {
+				failed = make(map[string]error)
+			}
+			failed[resource.kind] = err
+		}
+	}
+	return kinds, failed
+}
@@ -0,0 +1,164 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	k8s_nginx_fake "github.com/nginxinc/kubernetes-ingress/pkg/client/clientset/versioned/fake"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	gwFake "sigs.k8s.io/gateway-api/pkg/client/clientset/versioned/fake"
+)
+
+func TestSyncCRDs(t *testing.T) {
+	t.Cleanup(setupLookupFuncs)
+	clearLookupFuncs()
+
+	gwClient := gwFake.NewSimpleClientset()
+	addGateways(gwClient)
+	addHTTPRoutes(gwClient)
+
+	// CRDs are installed by flipping these flags, a missing CRD fails lists with a NoMatch error
+	var gatewaysInstalled, routesInstalled, broken atomic.Bool
+	crdReactor := func(installed *atomic.Bool, resource string) k8stesting.ReactionFunc {
+		return func(k8stesting.Action) (bool, runtime.Object, error) {
+			if broken.Load() {
+				return true, nil, errors.New("connection refused")
+			}
+			if !installed.Load() {
+				return true, nil, &meta.NoResourceMatchError{PartialResource: schema.GroupVersionResource{Resource: resource}}
+			}
+			return false, nil, nil
+		}
+	}
+	gwClient.PrependReactor("list", "gateways", crdReactor(&gatewaysInstalled, "gateways"))
+	gwClient.PrependReactor("list", "httproutes", crdReactor(&routesInstalled, "httproutes"))
+	// while stalled, only the given number of route lists succeed, so that informers never sync
+	var stalled atomic.Bool
+	var listsLeft atomic.Int32
+	gwClient.PrependReactor("list", "httproutes", func(k8stesting.Action) (bool, runtime.Object, error) {
+		if stalled.Load() && listsLeft.Add(-1) < 0 {
+			return true, nil, errors.New("stalled")
+		}
+		return false, nil, nil
+	})
+	never := &atomic.Bool{}
+	gwClient.PrependReactor("list", "tlsroutes", crdReactor(never, "tlsroutes"))
+	gwClient.PrependReactor("list", "grpcroutes", crdReactor(never, "grpcroutes"))
+
+	nginxClient := k8s_nginx_fake.NewSimpleClientset()
+	nginxClient.PrependReactor("list", "virtualservers", crdReactor(never, "virtualservers"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var rebuilds atomic.Int32
+	ctrl := newKubeController(ctx, "", fake.NewSimpleClientset(), gwClient, nginxClient, nil)
+	ctrl.onChange = func(keys []string) {
+		if keys == nil {
+			rebuilds.Add(1)
+		}
+	}
+	defer ctrl.stopCRDs()
+
+	routes := lookupResource("HTTPRoute")
+	gateways := lookupResource("Gateway")
+	routeKeys := []string{"route-1.gw-1.example.com"}
+
+	ctrl.syncCRDs(ctx)
+	if found := routes.find(routeKeys); len(found) != 0 {
+		t.Fatalf("Expected no routes without CRDs, got %v", found)
+	}
+
+	gatewaysInstalled.Store(true)
+	routesInstalled.Store(true)
+	ctrl.syncCRDs(ctx)
+	if found := routes.find(routeKeys); len(found) != 1 {
+		t.Errorf("Expected the route once CRDs are installed, got %v", found)
+	}
+	if keys := gateways.listKeys(); keys == nil {
+		t.Errorf("Expected the Gateway index to be bound")
+	}
+	if rebuilds.Load() == 0 {
+		t.Errorf("Expected a rebuild after the CRDs were installed")
+	}
+
+	// failing checks leave the running informers alone and are reported
+	gw := newGateway()
+	gw.Controller = ctrl
+	ctrl.synced.Store(true)
+
+	broken.Store(true)
+	if ctrl.syncCRDs(ctx) {
+		t.Errorf("Expected the sync to report failing checks")
+	}
+	if found := routes.find(routeKeys); len(found) != 1 {
+		t.Errorf("Expected the route to be kept while checks fail, got %v", found)
+	}
+	if failing := ctrl.Failing(); failing["HTTPRoute"] == nil || failing["VirtualServer"] == nil {
+		t.Errorf("Expected HTTPRoute and VirtualServer to be failing, got %v", failing)
+	}
+	if value := testutil.ToFloat64(resourceFailing.WithLabelValues("HTTPRoute", "")); value != 1 {
+		t.Errorf("Expected the failing metric to be set, got %v", value)
+	}
+	if gw.Ready() {
+		t.Errorf("Expected the plugin not to be ready while resources are failing")
+	}
+
+	broken.Store(false)
+	if !ctrl.syncCRDs(ctx) {
+		t.Errorf("Expected the sync to succeed once checks recover")
+	}
+	if failing := ctrl.Failing(); len(failing) != 0 {
+		t.Errorf("Expected no failing resources once checks recover, got %v", failing)
+	}
+	if !gw.Ready() {
+		t.Errorf("Expected the plugin to be ready once checks recover")
+	}
+
+	routesInstalled.Store(false)
+	ctrl.syncCRDs(ctx)
+	if found := routes.find(routeKeys); len(found) != 0 {
+		t.Errorf("Expected no routes once the CRD is removed, got %v", found)
+	}
+	if keys := gateways.listKeys(); keys == nil {
+		t.Errorf("Expected Gateways to be watched without routes")
+	}
+
+	// the running informers keep answering until the restarted ones have synced
+	routesInstalled.Store(true)
+	stalled.Store(true)
+	listsLeft.Store(1)
+	short, cancelShort := context.WithTimeout(ctx, 200*time.Millisecond)
+	if ctrl.syncCRDs(short) {
+		t.Errorf("Expected the sync to fail while the informers can't sync")
+	}
+	cancelShort()
+	if keys := gateways.listKeys(); keys == nil {
+		t.Errorf("Expected the Gateway index to stay bound while the group restarts")
+	}
+	stalled.Store(false)
+	if !ctrl.syncCRDs(ctx) {
+		t.Errorf("Expected the sync to succeed once the informers sync")
+	}
+	if found := routes.find(routeKeys); len(found) != 1 {
+		t.Errorf("Expected the route once the CRD is installed again, got %v", found)
+	}
+
+	before := rebuilds.Load()
+	gatewaysInstalled.Store(false)
+	ctrl.syncCRDs(ctx)
+	if keys := gateways.listKeys(); keys != nil {
+		t.Errorf("Expected no Gateway index once the CRD is removed, got %v", keys)
+	}
+	if rebuilds.Load() == before {
+		t.Errorf("Expected a rebuild after the CRDs were removed")
+	}
+}
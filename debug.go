@@ -0,0 +1,194 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"sort"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/reuseport"
+)
+
+// debugPath is where the names served by the plugin are listed on the debug listener
+const debugPath = "/names"
+
+// debugZone lists the names served in a zone
+type debugZone struct {
+	Zone  string      `json:"zone"`
+	Names []debugName `json:"names"`
+}
+
+// debugName is the answer served for a name, and the one served to the internal view if configured
+type debugName struct {
+	Name string `json:"name"`
+	debugAnswer
+	Internal *debugAnswer `json:"internal,omitempty"`
+}
+
+// debugAnswer is the records of a name and the objects they come from
+type debugAnswer struct {
+	TTL       uint32        `json:"ttl"`
+	CNAME     string        `json:"cname,omitempty"`
+	Addresses []netip.Addr  `json:"addresses,omitempty"`
+	Sources   []debugSource `json:"sources"`
+}
+
+// debugSource is an object that won the name, there are several when addresses are merged
+type debugSource struct {
+	Cluster   string       `json:"cluster,omitempty"`
+	Kind      string       `json:"kind"`
+	Namespace string       `json:"namespace,omitempty"`
+	Name      string       `json:"name,omitempty"`
+	CNAME     string       `json:"cname,omitempty"`
+	Addresses []netip.Addr `json:"addresses,omitempty"`
+}
+
+// startDebug serves the names listing on the debug address, if one is configured
+func (gw *Gateway) startDebug() error {
+	if gw.debugAddr == "" {
+		return nil
+	}
+
+	ln, err := reuseport.Listen("tcp", gw.debugAddr)
+	if err != nil {
+		return plugin.Error(thisPlugin, err)
+	}
+	gw.debugListener = ln
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(debugPath, gw.serveNames)
+	go func() { http.Serve(ln, mux) }()
+
+	return nil
+}
+
+// stopDebug closes the debug listener, which is reopened by the reloaded instance
+func (gw *Gateway) stopDebug() error {
+	if gw.debugListener == nil {
+		return nil
+	}
+	err := gw.debugListener.Close()
+	gw.debugListener = nil
+	return err
+}
+
+// serveNames lists every name of the snapshot by zone, as JSON or, with ?format=zone, as a zone file
+func (gw *Gateway) serveNames(w http.ResponseWriter, r *http.Request) {
+	zones := gw.debugZones()
+	if zones == nil {
+		http.Error(w, "Resources are not synced yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(zones); err != nil {
+			log.Errorf("Failed to send the names listing: %s", err)
+		}
+	case "zone":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		gw.writeZoneFile(w, zones)
+	default:
+		http.Error(w, fmt.Sprintf("Unknown format %q, expected json or zone", format), http.StatusBadRequest)
+	}
+}
+
+// debugZones groups the answers of the current snapshot by zone, sorted by name. It returns nil
+// until the snapshot is built.
+func (gw *Gateway) debugZones() []debugZone {
+	snap := gw.snapshot.Load()
+	if snap == nil {
+		return nil
+	}
+
+	byZone := make(map[string][]debugName)
+	for fqdn, ans := range snap.answers {
+		zone := plugin.Zones(gw.Zones).Matches(fqdn)
+		if zone == "" {
+			continue
+		}
+
+		name := debugName{Name: fqdn, debugAnswer: newDebugAnswer(ans)}
+		if ans.internal != nil {
+			internal := newDebugAnswer(ans.internal)
+			name.Internal = &internal
+		}
+		byZone[zone] = append(byZone[zone], name)
+	}
+
+	zones := make([]debugZone, 0, len(gw.Zones))
+	for _, zone := range gw.Zones {
+		names := byZone[zone]
+		sort.Slice(names, func(i, j int) bool { return names[i].Name < names[j].Name })
+		zones = append(zones, debugZone{Zone: zone, Names: append([]debugName{}, names...)})
+	}
+	return zones
+}
+
+// newDebugAnswer lists the records of an answer and the objects that won it
+func newDebugAnswer(ans *answer) debugAnswer {
+	listed := debugAnswer{TTL: ans.ttl, CNAME: ans.cname, Sources: []debugSource{}}
+	listed.Addresses = append(append(listed.Addresses, ans.ipv4...), ans.ipv6...)
+	for _, winner := range ans.winners {
+		source := debugSource{Cluster: winner.cluster, Kind: winner.kind, CNAME: winner.cname, Addresses: winner.addrs}
+		if winner.object != nil {
+			source.Namespace, source.Name = winner.object.GetNamespace(), winner.object.GetName()
+		}
+		listed.Sources = append(listed.Sources, source)
+	}
+	return listed
+}
+
+// writeZoneFile writes the records served for every name, with the objects they come from as comments.
+// The records of the internal view follow in a commented section, removing the leading "; " of its
+// lines gives the zone file of the view.
+func (gw *Gateway) writeZoneFile(w io.Writer, zones []debugZone) {
+	for _, zone := range zones {
+		zc := gw.configFor(zone.Zone)
+		fmt.Fprintf(w, "$ORIGIN %s\n", zone.Zone)
+
+		var internal bool
+		for _, name := range zone.Names {
+			writeRecords(w, zc, "", name.Name, name.debugAnswer)
+			internal = internal || name.Internal != nil
+		}
+
+		if internal {
+			fmt.Fprintln(w, "; internal view")
+			for _, name := range zone.Names {
+				if name.Internal != nil {
+					writeRecords(w, zc, "; ", name.Name, *name.Internal)
+				}
+			}
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// writeRecords writes the records of a name, each line starting with prefix. Names without
+// records in the answer are skipped.
+func writeRecords(w io.Writer, zc *zoneConfig, prefix, name string, ans debugAnswer) {
+	if ans.CNAME == "" && len(ans.Addresses) == 0 {
+		return
+	}
+	for _, source := range ans.Sources {
+		fmt.Fprintf(w, "%s; %s %s/%s\n", prefix, source.Kind, source.Namespace, source.Name)
+	}
+	if ans.CNAME != "" {
+		fmt.Fprintln(w, prefix+zc.CNAME(name, ans.TTL, ans.CNAME).String())
+		return
+	}
+	for _, addr := range ans.Addresses {
+		if addr.Is4() {
+			fmt.Fprintln(w, prefix+zc.A(name, ans.TTL, []netip.Addr{addr})[0].String())
+		} else {
+			fmt.Fprintln(w, prefix+zc.AAAA(name, ans.TTL, []netip.Addr{addr})[0].String())
+		}
+	}
+}
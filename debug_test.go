@@ -0,0 +1,128 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestServeNames(t *testing.T) {
+	gw := newGateway()
+	gw.Zones = []string{"example.com."}
+	gw.Controller = newSyncedController()
+	setupLookupFuncs()
+
+	get := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		gw.serveNames(w, httptest.NewRequest(http.MethodGet, url, nil))
+		return w
+	}
+
+	if w := get(debugPath); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected %d before the snapshot is built, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+
+	gw.buildSnapshot()
+
+	w := get(debugPath)
+	var zones []debugZone
+	if err := json.Unmarshal(w.Body.Bytes(), &zones); err != nil {
+		t.Fatalf("Expected a JSON listing, got %s: %s", err, w.Body)
+	}
+	if len(zones) != 1 || zones[0].Zone != "example.com." {
+		t.Fatalf("Expected the names of example.com., got %+v", zones)
+	}
+	var found *debugName
+	for i, name := range zones[0].Names {
+		if name.Name == "domain.example.com." {
+			found = &zones[0].Names[i]
+		}
+	}
+	if found == nil || len(found.Sources) != 1 || found.Sources[0].Kind != "Ingress" || found.TTL != ttlDefault {
+		t.Errorf("Expected domain.example.com. to be served from an Ingress, got %+v", found)
+	}
+
+	w = get(debugPath + "?format=zone")
+	if body := w.Body.String(); !strings.Contains(body, "$ORIGIN example.com.\n") ||
+		!strings.Contains(body, "; Ingress /\ndomain.example.com.\t60\tIN\tA\t192.0.0.1\n") {
+		t.Errorf("Expected a zone file with domain.example.com., got:\n%s", body)
+	}
+
+	if w := get(debugPath + "?format=yaml"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected %d for an unknown format, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	gw.debugAddr = "127.0.0.1:0"
+	if err := gw.startDebug(); err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	resp, err := http.Get("http://" + gw.debugListener.Addr().String() + debugPath)
+	if err != nil {
+		t.Fatalf("Expected the listing to be served, got %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if err := gw.stopDebug(); err != nil {
+		t.Errorf("Expected no error, got %s", err)
+	}
+}
+
+func TestServeNamesInternal(t *testing.T) {
+	t.Cleanup(setupLookupFuncs)
+
+	gw := newGateway()
+	gw.Zones = []string{"example.com."}
+	gw.Controller = newSyncedController()
+	gw.internalNets = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+
+	results := map[string]lookupResult{
+		"app": {
+			kind:     "Service",
+			addrs:    []netip.Addr{netip.MustParseAddr("198.51.100.1")},
+			internal: []netip.Addr{netip.MustParseAddr("10.96.0.1")},
+		},
+		"private": {
+			kind:     "Service",
+			internal: []netip.Addr{netip.MustParseAddr("10.96.0.2")},
+		},
+	}
+	clearLookupFuncs()
+	lookupResource("Service").setLookup("", gw, func(keys []string) (found []lookupResult) {
+		for _, key := range keys {
+			if result, ok := results[key]; ok {
+				found = append(found, result)
+			}
+		}
+		return found
+	}, func() []string { return []string{"app", "private"} })
+	gw.buildSnapshot()
+
+	zones := gw.debugZones()
+	if len(zones) != 1 || len(zones[0].Names) != 2 {
+		t.Fatalf("Expected the names of both views, got %+v", zones)
+	}
+	for _, name := range zones[0].Names {
+		if name.Internal == nil || len(name.Internal.Addresses) != 1 {
+			t.Errorf("Expected the internal answer of %s, got %+v", name.Name, name.Internal)
+		}
+	}
+	if private := zones[0].Names[1]; private.Name != "private.example.com." || len(private.Addresses) != 0 {
+		t.Errorf("Expected private.example.com. to only be answered in the internal view, got %+v", private)
+	}
+
+	w := httptest.NewRecorder()
+	gw.serveNames(w, httptest.NewRequest(http.MethodGet, debugPath+"?format=zone", nil))
+	expected := "$ORIGIN example.com.\n" +
+		"; Service /\napp.example.com.\t60\tIN\tA\t198.51.100.1\n" +
+		"; internal view\n" +
+		"; ; Service /\n; app.example.com.\t60\tIN\tA\t10.96.0.1\n" +
+		"; ; Service /\n; private.example.com.\t60\tIN\tA\t10.96.0.2\n\n"
+	if body := w.Body.String(); body != expected {
+		t.Errorf("Expected the internal view in a commented section, got:\n%s", body)
+	}
+}
@@ -0,0 +1,125 @@
+package gateway
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"sync"
+)
+
+// parsePrefixes parses the CIDRs of an allow_cidr or deny_cidr option
+func parsePrefixes(args []string) ([]netip.Prefix, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("expected a list of CIDRs")
+	}
+
+	prefixes := make([]netip.Prefix, 0, len(args))
+	for _, arg := range args {
+		prefix, err := netip.ParsePrefix(arg)
+		if err != nil {
+			return nil, fmt.Errorf("expected a list of CIDRs, got %s", arg)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	return slices.ContainsFunc(prefixes, func(prefix netip.Prefix) bool { return prefix.Contains(addr.Unmap()) })
+}
+
+// addrAllowed returns false for addresses in a denied network, and for addresses outside of the
+// allowed networks when any is configured
+func (zc *zoneConfig) addrAllowed(addr netip.Addr) bool {
+	if containsAddr(zc.denyCIDRs, addr) {
+		return false
+	}
+	return len(zc.allowCIDRs) == 0 || containsAddr(zc.allowCIDRs, addr)
+}
+
+// filterAddrs drops the addresses that aren't allowed in the zone of a name before conflicts are
+// resolved, so that an object left without addresses doesn't claim the name. The internal view is
+// meant to publish private addresses, its addresses aren't filtered.
+func (gw *Gateway) filterAddrs(zc *zoneConfig, zone, name string, results []lookupResult) []lookupResult {
+	allowed, filtered := zc.allowedAddrs(results)
+	gw.filtered.report(zone, name, filtered)
+	return allowed
+}
+
+// filteredAddr is an address dropped from the results of an object
+type filteredAddr struct {
+	result lookupResult
+	addr   netip.Addr
+}
+
+func (f filteredAddr) String() string {
+	return f.addr.String() + " of " + f.result.String()
+}
+
+// allowedAddrs splits the addresses of every result into the allowed ones and the filtered ones
+func (zc *zoneConfig) allowedAddrs(results []lookupResult) ([]lookupResult, []filteredAddr) {
+	if len(zc.allowCIDRs) == 0 && len(zc.denyCIDRs) == 0 {
+		return results, nil
+	}
+
+	allowed := make([]lookupResult, 0, len(results))
+	var filtered []filteredAddr
+	for _, result := range results {
+		var addrs []netip.Addr
+		for _, addr := range result.addrs {
+			if !zc.addrAllowed(addr) {
+				filtered = append(filtered, filteredAddr{result: result, addr: addr})
+				continue
+			}
+			addrs = append(addrs, addr)
+		}
+		result.addrs = addrs
+		allowed = append(allowed, result)
+	}
+	return allowed, filtered
+}
+
+// filterTracker remembers the addresses filtered out of each name, so that they are only logged
+// and counted when they start being filtered, not every time the answer is computed
+type filterTracker struct {
+	sync.Mutex
+	seen map[string]map[string]struct{}
+}
+
+func newFilterTracker() *filterTracker {
+	return &filterTracker{seen: make(map[string]map[string]struct{})}
+}
+
+func (t *filterTracker) report(zone, name string, filtered []filteredAddr) {
+	t.Lock()
+	defer t.Unlock()
+
+	last := t.seen[name]
+	current := make(map[string]struct{}, len(filtered))
+	for _, f := range filtered {
+		id := f.String()
+		current[id] = struct{}{}
+		if _, ok := last[id]; ok {
+			continue
+		}
+		log.Debugf("Filtered address %s for %s in zone %s", f, name, zone)
+		filteredAddresses.WithLabelValues(zone, f.result.kind).Inc()
+	}
+
+	if len(current) == 0 {
+		delete(t.seen, name)
+		return
+	}
+	t.seen[name] = current
+}
+
+// prune forgets the names that are no longer indexed
+func (t *filterTracker) prune(fqdns map[string]struct{}) {
+	t.Lock()
+	defer t.Unlock()
+	for name := range t.seen {
+		if _, ok := fqdns[name]; !ok {
+			delete(t.seen, name)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestFilterAddrs(t *testing.T) {
+	addrs := []netip.Addr{
+		netip.MustParseAddr("192.0.2.1"),
+		netip.MustParseAddr("10.0.0.1"),
+		netip.MustParseAddr("169.254.0.1"),
+		netip.MustParseAddr("2001:db8::1"),
+	}
+
+	tests := []struct {
+		allow    []string
+		deny     []string
+		expected string
+	}{
+		{nil, nil, "[192.0.2.1 10.0.0.1 169.254.0.1 2001:db8::1]"},
+		{nil, []string{"10.0.0.0/8", "169.254.0.0/16"}, "[192.0.2.1 2001:db8::1]"},
+		{[]string{"192.0.2.0/24"}, nil, "[192.0.2.1]"},
+		{[]string{"0.0.0.0/0", "::/0"}, []string{"10.0.0.0/8"}, "[192.0.2.1 169.254.0.1 2001:db8::1]"},
+		{[]string{"198.51.100.0/24"}, nil, "[]"},
+	}
+	for i, tc := range tests {
+		zc := &zoneConfig{}
+		for _, cidr := range tc.allow {
+			zc.allowCIDRs = append(zc.allowCIDRs, netip.MustParsePrefix(cidr))
+		}
+		for _, cidr := range tc.deny {
+			zc.denyCIDRs = append(zc.denyCIDRs, netip.MustParsePrefix(cidr))
+		}
+
+		result := lookupResult{kind: "Service", addrs: addrs, internal: addrs}
+		allowed, filtered := zc.allowedAddrs([]lookupResult{result})
+		if len(allowed) != 1 || fmt.Sprint(allowed[0].addrs) != tc.expected {
+			t.Errorf("Test %d: expected addresses %s, got %v", i, tc.expected, allowed)
+			continue
+		}
+		if len(allowed[0].addrs)+len(filtered) != len(addrs) {
+			t.Errorf("Test %d: expected the other addresses to be reported as filtered, got %v", i, filtered)
+		}
+		if len(allowed[0].internal) != len(addrs) {
+			t.Errorf("Test %d: expected the internal addresses to be kept, got %v", i, allowed[0].internal)
+		}
+	}
+}
+
+func TestFilteredAnswer(t *testing.T) {
+	gw := newGateway()
+	gw.Zones = []string{"example.com."}
+	gw.denyCIDRs = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+
+	clearLookupFuncs()
+	lookupResource("Service").setLookup("", gw, func(keys []string) (results []lookupResult) {
+		for _, key := range keys {
+			switch key {
+			case "leak.example.com":
+				results = append(results, lookupResult{kind: "Service", addrs: []netip.Addr{netip.MustParseAddr("10.0.0.1")}})
+			case "app.example.com":
+				results = append(results, lookupResult{kind: "Service", addrs: []netip.Addr{netip.MustParseAddr("10.0.0.2")}})
+				results = append(results, lookupResult{kind: "Service", addrs: []netip.Addr{netip.MustParseAddr("192.0.2.1")}})
+			}
+		}
+		return results
+	}, func() []string { return []string{"leak.example.com", "app.example.com"} })
+
+	if ans := gw.computeAnswer("leak.example.com."); ans != nil {
+		t.Errorf("Expected no answer for a name with only denied addresses, got %v", ans.ipv4)
+	}
+	if ans := gw.computeAnswer("app.example.com."); ans == nil || fmt.Sprint(ans.ipv4) != "[192.0.2.1]" {
+		t.Errorf("Expected only the allowed address, got %v", ans)
+	}
+
+	// addresses are only counted when they start being filtered, not on every rebuild
+	counter := filteredAddresses.WithLabelValues("example.com.", "Service")
+	gw.buildSnapshot()
+	before := testutil.ToFloat64(counter)
+	gw.buildSnapshot()
+	if value := testutil.ToFloat64(counter) - before; value != 0 {
+		t.Errorf("Expected the known filtered addresses not to be counted again, got %v", value)
+	}
+	// names that are no longer indexed are forgotten, and counted again if they come back, both
+	// addresses are filtered out of the name and of the name appended to the zone
+	gw.filtered.prune(nil)
+	gw.buildSnapshot()
+	if value := testutil.ToFloat64(counter) - before; value != 4 {
+		t.Errorf("Expected both filtered addresses to be counted for each name, got %v", value)
+	}
+}
+
+func TestFilteredNameservers(t *testing.T) {
+	gw := newGateway()
+	gw.Zones = []string{"example.com."}
+	gw.Next = test.NextHandler(dns.RcodeSuccess, nil)
+	gw.Controller = newSyncedController()
+	gw.ExternalAddrFunc = gw.SelfAddress
+	gw.denyCIDRs = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+
+	clearLookupFuncs()
+	lookupResource("Service").setLookup("", gw, func(keys []string) (results []lookupResult) {
+		for _, key := range keys {
+			if key == "dns1.kube-system" {
+				results = append(results, lookupResult{kind: "Service", addrs: []netip.Addr{
+					netip.MustParseAddr("10.0.0.53"), netip.MustParseAddr("192.0.2.53"),
+				}})
+			}
+		}
+		return results
+	}, func() []string { return []string{"dns1.kube-system"} })
+
+	tests := []test.Case{
+		{
+			Qname: "example.com.", Qtype: dns.TypeNS,
+			Rcode: dns.RcodeSuccess,
+			Answer: []dns.RR{
+				test.NS("example.com.	60	IN	NS	dns1.kube-system.example.com."),
+			},
+			Extra: []dns.RR{
+				test.A("dns1.kube-system.example.com.	60	IN	A	192.0.2.53"),
+			},
+		},
+		{
+			Qname: "dns1.kube-system.example.com.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeSuccess,
+			Answer: []dns.RR{
+				test.A("dns1.kube-system.example.com.	60	IN	A	192.0.2.53"),
+			},
+		},
+	}
+
+	for i, tc := range tests {
+		w := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := gw.ServeDNS(context.TODO(), w, tc.Msg()); err != nil {
+			t.Errorf("Test %d: expected no error, got %v", i, err)
+			continue
+		}
+		if err := test.SortAndCheck(w.Msg, tc); err != nil {
+			t.Errorf("Test %d: %v", i, err)
+		}
+	}
+}
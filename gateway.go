@@ -3,24 +3,148 @@ package gateway
 import (
 	"context"
 	"fmt"
+	"math"
+	"math/rand"
 	"net"
 	"net/netip"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/dnsutil"
 	"github.com/coredns/coredns/plugin/pkg/fall"
 	"github.com/coredns/coredns/request"
 	"github.com/miekg/dns"
 )
 
-type lookupFunc func(indexKeys []string) []netip.Addr
+// lookupResult holds the addresses published for a hostname by a single Kubernetes object
+type lookupResult struct {
+	kind    string
+	object  kubeObject
+	addrs   []netip.Addr
+	cname   string
+	cluster string
+	// internal and internalCNAME replace addrs and cname in the internal view, when set
+	internal      []netip.Addr
+	internalCNAME string
+	// regions holds the region of addresses inherited from other objects, e.g. the parents of a route
+	regions map[netip.Addr]string
+}
+
+type lookupFunc func(indexKeys []string) []lookupResult
 
 type resourceWithIndex struct {
+	sync.RWMutex
 	name   string
 	lookup lookupFunc
+	// keys lists every index key of the resource, used to build the answer snapshot
+	keys func() []string
+	// clusters holds the lookup bound for each cluster, lookup and keys combine all of them
+	clusters map[string]clusterLookup
+}
+
+// clusterLookup is the lookup of a resource in a single cluster. owner is whatever bound it, so
+// that it is only cleared by the same owner.
+type clusterLookup struct {
+	owner  any
+	lookup lookupFunc
+	keys   func() []string
+}
+
+// setLookup swaps the lookup of a resource in a cluster, e.g. when the informer behind it is started
+func (r *resourceWithIndex) setLookup(cluster string, owner any, lookup lookupFunc, keys func() []string) {
+	r.Lock()
+	defer r.Unlock()
+	if r.clusters == nil {
+		r.clusters = make(map[string]clusterLookup)
+	}
+	r.clusters[cluster] = clusterLookup{owner: owner, lookup: lookup, keys: keys}
+	r.combine()
+}
+
+// clearLookup resets the lookup of a resource in a cluster, unless it has been bound by another owner since
+func (r *resourceWithIndex) clearLookup(cluster string, owner any) {
+	r.Lock()
+	defer r.Unlock()
+	if l, ok := r.clusters[cluster]; ok && l.owner == owner {
+		delete(r.clusters, cluster)
+		r.combine()
+	}
+}
+
+// combine builds the lookup of all clusters, which tags the results with their cluster. It must be
+// called with the lock held.
+func (r *resourceWithIndex) combine() {
+	names := make([]string, 0, len(r.clusters))
+	for name := range r.clusters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	switch {
+	case len(names) == 0:
+		r.lookup, r.keys = noop, nil
+		return
+	case len(names) == 1 && names[0] == "":
+		// a single unnamed cluster, as without multiple kubeconfigs
+		r.lookup, r.keys = r.clusters[""].lookup, r.clusters[""].keys
+		return
+	}
+
+	lookups := make([]clusterLookup, len(names))
+	for i, name := range names {
+		lookups[i] = r.clusters[name]
+	}
+	r.lookup = func(indexKeys []string) (results []lookupResult) {
+		for i, l := range lookups {
+			found := l.lookup(indexKeys)
+			for j := range found {
+				found[j].cluster = names[i]
+			}
+			results = append(results, found...)
+		}
+		return results
+	}
+	r.keys = func() []string {
+		seen := make(map[string]struct{})
+		var keys []string
+		for _, l := range lookups {
+			if l.keys == nil {
+				continue
+			}
+			for _, key := range l.keys() {
+				if _, ok := seen[key]; !ok {
+					seen[key] = struct{}{}
+					keys = append(keys, key)
+				}
+			}
+		}
+		return keys
+	}
 }
 
-var noop lookupFunc = func([]string) (result []netip.Addr) { return }
+func (r *resourceWithIndex) find(indexKeys []string) []lookupResult {
+	r.RLock()
+	lookup := r.lookup
+	r.RUnlock()
+	return lookup(indexKeys)
+}
+
+func (r *resourceWithIndex) listKeys() []string {
+	r.RLock()
+	keys := r.keys
+	r.RUnlock()
+	if keys == nil {
+		return nil
+	}
+	return keys()
+}
+
+var noop lookupFunc = func([]string) (result []lookupResult) { return }
 
 var orderedResources = []*resourceWithIndex{
 	{
@@ -35,6 +159,10 @@ var orderedResources = []*resourceWithIndex{
 		name:   "GRPCRoute",
 		lookup: noop,
 	},
+	{
+		name:   "Gateway",
+		lookup: noop,
+	},
 	{
 		name:   "VirtualServer",
 		lookup: noop,
@@ -51,38 +179,81 @@ var orderedResources = []*resourceWithIndex{
 
 var (
 	ttlDefault        = uint32(60)
+	ttlMinDefault     = uint32(0)
+	ttlMaxDefault     = uint32(3600)
 	ttlSOA            = uint32(60)
 	defaultApex       = "dns1.kube-system"
 	defaultHostmaster = "hostmaster"
 	defaultSecondNS   = ""
+	defaultWeight     = 1
 )
 
+// zoneConfig stores the part of the plugin configuration that can be overridden per zone
+type zoneConfig struct {
+	Resources  []*resourceWithIndex
+	ttlLow     uint32
+	ttlSOA     uint32
+	apex       string
+	hostmaster string
+	secondNS   string
+	ttlMin     uint32
+	ttlMax     uint32
+	merge      bool
+	weights    map[string]int
+	// allowCIDRs and denyCIDRs filter the addresses published in the zone
+	allowCIDRs []netip.Prefix
+	denyCIDRs  []netip.Prefix
+}
+
 // Gateway stores all runtime configuration of a plugin
 type Gateway struct {
-	Next             plugin.Handler
-	Zones            []string
-	Resources        []*resourceWithIndex
-	ttlLow           uint32
-	ttlSOA           uint32
-	Controller       *KubeController
-	apex             string
-	hostmaster       string
-	secondNS         string
-	configFile       string
-	configContext    string
-	ExternalAddrFunc func(request.Request) []dns.RR
+	zoneConfig
+	Next               plugin.Handler
+	Zones              []string
+	zoneConfigs        map[string]*zoneConfig
+	Controller         *KubeController
+	clusters           []clusterConfig
+	clusterPolicy      clusterPolicy
+	clusterControllers []*KubeController
+	resolverUpstream   string
+	partial            bool
+	conflict           conflictPolicy
+	conflicts          *conflictTracker
+	filtered           *filterTracker
+	snapshot           atomic.Pointer[snapshot]
+	pending            *pendingChanges
+	snapshotChanged    chan struct{}
+	cancel             context.CancelFunc
+	debugAddr          string
+	debugListener      net.Listener
+	internalNets       []netip.Prefix
+	regions            regionMap
+	health             healthMode
+	publish            bool
+	publishNamespace   string
+	ExternalAddrFunc   func(request.Request) []dns.RR
 
 	Fall fall.F
 }
 
 func newGateway() *Gateway {
 	return &Gateway{
-		Resources:  orderedResources,
-		ttlLow:     ttlDefault,
-		ttlSOA:     ttlSOA,
-		apex:       defaultApex,
-		secondNS:   defaultSecondNS,
-		hostmaster: defaultHostmaster,
+		zoneConfig: zoneConfig{
+			Resources:  orderedResources,
+			ttlLow:     ttlDefault,
+			ttlSOA:     ttlSOA,
+			ttlMin:     ttlMinDefault,
+			ttlMax:     ttlMaxDefault,
+			apex:       defaultApex,
+			secondNS:   defaultSecondNS,
+			hostmaster: defaultHostmaster,
+		},
+		zoneConfigs: make(map[string]*zoneConfig),
+		conflicts:   newConflictTracker(),
+		filtered:    newFilterTracker(),
+		pending:     newPendingChanges(),
+		// snapshotChanged wakes up the publisher, a single pending signal is enough
+		snapshotChanged: make(chan struct{}, 1),
 	}
 }
 
@@ -96,17 +267,75 @@ func lookupResource(resource string) *resourceWithIndex {
 	return nil
 }
 
-func (gw *Gateway) updateResources(newResources []string) {
+func (zc *zoneConfig) updateResources(newResources []string) {
 
-	gw.Resources = []*resourceWithIndex{}
+	zc.Resources = []*resourceWithIndex{}
 
 	for _, name := range newResources {
 		if resource := lookupResource(name); resource != nil {
-			gw.Resources = append(gw.Resources, resource)
+			zc.Resources = append(zc.Resources, resource)
 		}
 	}
 }
 
+// orderByWeight shuffles the results so that each resource kind comes first with a probability
+// proportional to its weight, when merged. Objects with a weight annotation are shuffled on their
+// own. Zero weights are dropped, without weights the order is kept.
+func (zc *zoneConfig) orderByWeight(results []lookupResult) []lookupResult {
+	kindWeights := zc.merge && len(zc.weights) > 0
+	if !kindWeights && !weighted(results) {
+		return results
+	}
+
+	type group struct {
+		key     float64
+		results []lookupResult
+	}
+	var groups []*group
+	byKey := make(map[string]*group)
+
+	for _, result := range results {
+		key := result.kind
+		weight, own := result.weight()
+		if own {
+			key = objectKey(result)
+		}
+		if g, ok := byKey[key]; ok {
+			g.results = append(g.results, result)
+			continue
+		}
+		if !own {
+			var ok bool
+			if weight, ok = zc.weights[result.kind]; !ok || !kindWeights {
+				weight = defaultWeight
+			}
+		}
+		if weight == 0 {
+			continue
+		}
+		// weighted random sampling without replacement (Efraimidis-Spirakis)
+		g := &group{key: math.Pow(rand.Float64(), 1/float64(weight)), results: []lookupResult{result}}
+		byKey[key] = g
+		groups = append(groups, g)
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool { return groups[i].key > groups[j].key })
+
+	var ordered []lookupResult
+	for _, g := range groups {
+		ordered = append(ordered, g.results...)
+	}
+	return ordered
+}
+
+// configFor returns the configuration of a zone, falling back to the plugin-wide one
+func (gw *Gateway) configFor(zone string) *zoneConfig {
+	if zc, ok := gw.zoneConfigs[strings.ToLower(zone)]; ok {
+		return zc
+	}
+	return &gw.zoneConfig
+}
+
 // ServeDNS implements the plugin.Handle interface.
 func (gw *Gateway) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
 	state := request.Request{W: w, Req: r}
@@ -118,25 +347,17 @@ func (gw *Gateway) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Ms
 		log.Debugf("Request %s has not matched any zones %v", qname, gw.Zones)
 		return plugin.NextOrFailure(gw.Name(), gw.Next, ctx, w, r)
 	}
+	zc := gw.configFor(zone)
+	zoneLabel := zone
 	zone = qname[len(qname)-len(zone):] // maintain case of original query
 	state.Zone = zone
 
-	// Indexer cache can be built from `name.namespace` without zone
-	zonelessQuery := stripDomain(qname, zone)
-
-	// Computing keys to look up in cache
-	var indexKeys []string
-	strippedQName := stripClosingDot(state.QName())
-	if len(zonelessQuery) != 0 && zonelessQuery != strippedQName {
-		indexKeys = []string{strippedQName, zonelessQuery}
-	} else {
-		indexKeys = []string{strippedQName}
-	}
-	log.Debugf("Computed Index Keys %v", indexKeys)
-
-	if !gw.Controller.HasSynced() {
-		// TODO maybe there's a better way to do this? e.g. return an error back to the client?
-		return dns.RcodeServerFailure, plugin.Error(thisPlugin, fmt.Errorf("Could not sync required resources"))
+	// with partial answers enabled, names found in the caches filled so far are answered before
+	// all resources are synced
+	synced := gw.hasSynced()
+	if !synced && !gw.partial {
+		observeQuery(ctx, zoneLabel, state.QType(), dns.RcodeServerFailure, nil)
+		return dns.RcodeServerFailure, plugin.Error(thisPlugin, fmt.Errorf("Could not sync required resources %v", gw.unsynced()))
 	}
 
 	var isRootZoneQuery bool
@@ -145,111 +366,100 @@ func (gw *Gateway) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Ms
 			isRootZoneQuery = true
 			break
 		}
-		if dns.IsSubDomain(gw.apex+"."+z, state.Name()) {
+		if dns.IsSubDomain(gw.configFor(z).apex+"."+z, state.Name()) {
 			// dns subdomain test for ns. and dns. queries
 			ret, err := gw.serveSubApex(state)
+			observeQuery(ctx, zoneLabel, state.QType(), ret, nil)
 			return ret, err
 		}
 	}
 
-	var addrs []netip.Addr
+	start := time.Now()
+	internal := gw.isInternal(state)
+	ans := gw.answerFor(qname, zc, internal)
+	observeLookup(ctx, zoneLabel, start)
 
-	// Iterate over supported resources and lookup DNS queries
-	// Stop once we've found at least one match
-	for _, resource := range gw.Resources {
-		addrs = resource.lookup(indexKeys)
-		if len(addrs) > 0 {
-			break
-		}
+	// a name missing from partial caches may still be held by a resource that isn't synced yet
+	if ans == nil && !synced {
+		observeQuery(ctx, zoneLabel, state.QType(), dns.RcodeServerFailure, nil)
+		return dns.RcodeServerFailure, plugin.Error(thisPlugin, fmt.Errorf("Could not sync required resources %v", gw.unsynced()))
 	}
-	log.Debugf("Computed response addresses %v", addrs)
+
+	// names without records of their own exist if names below them do, e.g. ns.example.com for
+	// app.ns.example.com or the parents of the nameserver names
+	nonTerminal := ans == nil && (gw.isNonTerminal(qname, internal) || dns.IsSubDomain(qname, dnsutil.Join(zc.apex, zone)))
+	exists := ans != nil || isRootZoneQuery || nonTerminal
 
 	// Fall through if no host matches
-	if len(addrs) == 0 && gw.Fall.Through(qname) {
+	if ans == nil && !nonTerminal && gw.Fall.Through(qname) {
 		return plugin.NextOrFailure(gw.Name(), gw.Next, ctx, w, r)
 	}
+	if ans == nil {
+		ans = &answer{ttl: zc.ttlLow}
+	}
+	log.Debugf("Computed response addresses %v %v with ttl %d", ans.ipv4, ans.ipv6, ans.ttl)
 
 	m := new(dns.Msg)
 	m.SetReply(state.Req)
 
-	var ipv4Addrs []netip.Addr
-	var ipv6Addrs []netip.Addr
+	if ans.cname != "" && !isRootZoneQuery {
+		m.Answer = []dns.RR{zc.CNAME(state.Name(), ans.ttl, ans.cname)}
+		m.Authoritative = true
+		_, _, ecs := gw.nearestAddrs(state, nil)
+		setClientSubnet(state, m, ecs)
 
-	for _, addr := range addrs {
-		if addr.Is4() {
-			ipv4Addrs = append(ipv4Addrs, addr)
-		}
-		if addr.Is6() {
-			ipv6Addrs = append(ipv6Addrs, addr)
+		if err := w.WriteMsg(m); err != nil {
+			log.Errorf("Failed to send a response: %s", err)
 		}
+		observeQuery(ctx, zoneLabel, state.QType(), m.Rcode, ans)
+		return dns.RcodeSuccess, nil
 	}
 
+	ttl := ans.ttl
+	ipv4Addrs, ipv6Addrs, ecs := gw.nearestAddrs(state, ans)
+
 	switch state.QType() {
 	case dns.TypeA:
+		m.Answer = zc.A(state.Name(), ttl, ipv4Addrs)
 
-		if len(ipv4Addrs) == 0 {
-
-			if !isRootZoneQuery {
-				// No match, return NXDOMAIN
-				m.Rcode = dns.RcodeNameError
-			}
-
-			m.Ns = []dns.RR{gw.soa(state)}
-
-		} else {
-
-			m.Answer = gw.A(state.Name(), ipv4Addrs)
-		}
 	case dns.TypeAAAA:
-
-		if len(ipv6Addrs) == 0 {
-
-			if !isRootZoneQuery {
-				// No match, return NXDOMAIN
-				m.Rcode = dns.RcodeNameError
-			}
-
-			// as per rfc4074 #3
-			if len(ipv4Addrs) > 0 {
-				m.Rcode = dns.RcodeSuccess
-			}
-
-			m.Ns = []dns.RR{gw.soa(state)}
-
-		} else {
-
-			m.Answer = gw.AAAA(state.Name(), ipv6Addrs)
-		}
+		m.Answer = zc.AAAA(state.Name(), ttl, ipv6Addrs)
 
 	case dns.TypeSOA:
-
-		m.Answer = []dns.RR{gw.soa(state)}
+		if isRootZoneQuery {
+			m.Answer = []dns.RR{zc.soa(state)}
+		}
 
 	case dns.TypeNS:
-
 		if isRootZoneQuery {
-			m.Answer = gw.nameservers(state)
+			m.Answer = zc.nameservers(state)
 
 			addr := gw.ExternalAddrFunc(state)
 			for _, rr := range addr {
-				rr.Header().Ttl = gw.ttlSOA
+				rr.Header().Ttl = zc.ttlSOA
 				m.Extra = append(m.Extra, rr)
 			}
-		} else {
-			m.Ns = []dns.RR{gw.soa(state)}
 		}
+	}
 
-	default:
-		m.Ns = []dns.RR{gw.soa(state)}
+	// a name that exists without records of the queried type gets NODATA (rfc2308 #2.2, rfc4074
+	// #3), any other name NXDOMAIN
+	if len(m.Answer) == 0 {
+		if !exists {
+			m.Rcode = dns.RcodeNameError
+		}
+		m.Ns = []dns.RR{zc.soa(state)}
 	}
 
 	// Force to true to fix broken behaviour of legacy glibc `getaddrinfo`.
 	// See https://github.com/coredns/coredns/pull/3573
 	m.Authoritative = true
+	setClientSubnet(state, m, ecs)
 
 	if err := w.WriteMsg(m); err != nil {
 		log.Errorf("Failed to send a response: %s", err)
 	}
+	observeQuery(ctx, zoneLabel, state.QType(), m.Rcode, ans)
 
 	return dns.RcodeSuccess, nil
 }
@@ -257,48 +467,103 @@ func (gw *Gateway) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Ms
 // Name implements the Handler interface.
 func (gw *Gateway) Name() string { return thisPlugin }
 
-// A does the A-record lookup in ingress indexer
-func (gw *Gateway) A(name string, results []netip.Addr) (records []dns.RR) {
-	dup := make(map[string]struct{})
+// Ready implements the ready.Readiness interface. The plugin isn't ready until the caches are synced,
+// nor while any resource is failing, although the available resources are still served. With several
+// clusters, it is ready as long as one of them is healthy.
+func (gw *Gateway) Ready() bool {
+	if !gw.hasSynced() {
+		return false
+	}
+	for _, ctrl := range gw.controllers() {
+		if gw.clusterHealthy(ctrl.cluster) {
+			return true
+		}
+	}
+	return false
+}
+
+// answerTTL returns the TTL of an answer, which is the lowest TTL requested by any of the objects
+// contributing to it. Annotated TTLs are clamped to the configured bounds, objects without the
+// annotation use the zone TTL.
+func (zc *zoneConfig) answerTTL(results []lookupResult) uint32 {
+	if len(results) == 0 {
+		return zc.ttlLow
+	}
+
+	ttl := uint32(math.MaxUint32)
 	for _, result := range results {
-		if _, ok := dup[result.String()]; !ok {
-			dup[result.String()] = struct{}{}
-			records = append(records, &dns.A{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: gw.ttlLow}, A: net.ParseIP(result.String())})
+		objTTL := zc.ttlLow
+		if annotated, ok := result.ttl(); ok {
+			objTTL = min(max(annotated, zc.ttlMin), zc.ttlMax)
+		}
+		ttl = min(ttl, objTTL)
+	}
+	return ttl
+}
+
+// A does the A-record lookup in ingress indexer
+func (zc *zoneConfig) A(name string, ttl uint32, results []netip.Addr) (records []dns.RR) {
+	for i, result := range results {
+		if slices.Contains(results[:i], result) {
+			continue
 		}
+		records = append(records, &dns.A{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl}, A: net.IP(result.AsSlice())})
 	}
 	return records
 }
 
-func (gw *Gateway) AAAA(name string, results []netip.Addr) (records []dns.RR) {
-	dup := make(map[string]struct{})
-	for _, result := range results {
-		if _, ok := dup[result.String()]; !ok {
-			dup[result.String()] = struct{}{}
-			records = append(records, &dns.AAAA{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: gw.ttlLow}, AAAA: net.ParseIP(result.String())})
+func (zc *zoneConfig) AAAA(name string, ttl uint32, results []netip.Addr) (records []dns.RR) {
+	for i, result := range results {
+		if slices.Contains(results[:i], result) {
+			continue
 		}
+		records = append(records, &dns.AAAA{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: ttl}, AAAA: net.IP(result.AsSlice())})
 	}
 	return records
 }
 
-// SelfAddress returns the address of the local k8s_gateway service
-func (gw *Gateway) SelfAddress(state request.Request) (records []dns.RR) {
+// CNAME builds the record for a hostname target
+func (zc *zoneConfig) CNAME(name string, ttl uint32, target string) dns.RR {
+	return &dns.CNAME{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: ttl}, Target: target}
+}
 
-	var addrs1, addrs2 []netip.Addr
-	for _, resource := range gw.Resources {
-		results := resource.lookup([]string{gw.apex})
-		if len(results) > 0 {
-			addrs1 = append(addrs1, results...)
-		}
-		results = resource.lookup([]string{gw.secondNS})
-		if len(results) > 0 {
-			addrs2 = append(addrs2, results...)
+// nameserverAddrs returns the addresses of the objects published as a nameserver name. The load
+// balancer of a Service may have addresses of families it doesn't serve, e.g. a hostname resolving
+// to both, only those in its ipFamilies are kept. The CIDR filters of the zone apply as well.
+func (gw *Gateway) nameserverAddrs(zc *zoneConfig, zone, name string) (ipv4, ipv6 []netip.Addr) {
+	var results []lookupResult
+	for _, resource := range zc.Resources {
+		results = append(results, resource.find([]string{name})...)
+	}
+
+	for _, result := range gw.filterAddrs(zc, zone, strings.ToLower(name+"."+zone), results) {
+		for _, addr := range result.addrs {
+			switch {
+			case !servesFamily(result.object, addr):
+			case addr.Is4():
+				ipv4 = append(ipv4, addr)
+			case addr.Is6():
+				ipv6 = append(ipv6, addr)
+			}
 		}
 	}
+	return ipv4, ipv6
+}
+
+// SelfAddress returns the address of the local k8s_gateway service
+func (gw *Gateway) SelfAddress(state request.Request) (records []dns.RR) {
+
+	zc := gw.configFor(state.Zone)
+	zone := plugin.Zones(gw.Zones).Matches(state.Zone)
 
-	records = append(records, gw.A(gw.apex+"."+state.Zone, addrs1)...)
+	ipv4, ipv6 := gw.glueFor(zc, zone, zc.apex)
+	records = append(records, zc.A(zc.apex+"."+state.Zone, zc.ttlSOA, ipv4)...)
+	records = append(records, zc.AAAA(zc.apex+"."+state.Zone, zc.ttlSOA, ipv6)...)
 
-	if state.QType() == dns.TypeNS {
-		records = append(records, gw.A(gw.secondNS+"."+state.Zone, addrs2)...)
+	if state.QType() == dns.TypeNS && zc.secondNS != "" {
+		ipv4, ipv6 = gw.glueFor(zc, zone, zc.secondNS)
+		records = append(records, zc.A(zc.secondNS+"."+state.Zone, zc.ttlSOA, ipv4)...)
+		records = append(records, zc.AAAA(zc.secondNS+"."+state.Zone, zc.ttlSOA, ipv6)...)
 	}
 
 	return records
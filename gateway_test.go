@@ -13,6 +13,8 @@ import (
 	"github.com/coredns/coredns/plugin/test"
 
 	"github.com/miekg/dns"
+	networking "k8s.io/api/networking/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 type FallthroughCase struct {
@@ -26,8 +28,8 @@ type Fallen struct {
 }
 
 func TestLookup(t *testing.T) {
-	real := []string{"Ingress", "Service", "HTTPRoute", "TLSRoute", "GRPCRoute", "VirtualServer"}
-	fake := []string{"Pod", "Gateway"}
+	real := []string{"Ingress", "Service", "HTTPRoute", "TLSRoute", "GRPCRoute", "Gateway", "VirtualServer"}
+	fake := []string{"Pod", "Secret"}
 
 	for _, resource := range real {
 		if found := lookupResource(resource); found == nil {
@@ -44,7 +46,7 @@ func TestLookup(t *testing.T) {
 
 func TestPlugin(t *testing.T) {
 
-	ctrl := &KubeController{hasSynced: true}
+	ctrl := newSyncedController()
 
 	gw := newGateway()
 	gw.Zones = []string{"example.com."}
@@ -80,7 +82,7 @@ func TestPlugin(t *testing.T) {
 
 func TestPluginFallthrough(t *testing.T) {
 
-	ctrl := &KubeController{hasSynced: true}
+	ctrl := newSyncedController()
 	gw := newGateway()
 	gw.Zones = []string{"example.com."}
 	gw.Next = test.NextHandler(dns.RcodeSuccess, Fallen{})
@@ -105,6 +107,231 @@ func TestPluginFallthrough(t *testing.T) {
 	}
 }
 
+func TestPluginPartial(t *testing.T) {
+
+	gw := newGateway()
+	gw.Zones = []string{"example.com."}
+	gw.Next = test.NextHandler(dns.RcodeSuccess, nil)
+	gw.Controller = &KubeController{}
+	setupLookupFuncs()
+
+	ctx := context.TODO()
+	query := func(name string) int {
+		r := new(dns.Msg)
+		r.SetQuestion(name, dns.TypeA)
+		w := dnstest.NewRecorder(&test.ResponseWriter{})
+		rcode, _ := gw.ServeDNS(ctx, w, r)
+		if w.Msg != nil {
+			return w.Msg.Rcode
+		}
+		return rcode
+	}
+
+	if rcode := query("domain.example.com."); rcode != dns.RcodeServerFailure {
+		t.Errorf("Expected SERVFAIL before sync, got %s", dns.RcodeToString[rcode])
+	}
+
+	gw.partial = true
+	if rcode := query("domain.example.com."); rcode != dns.RcodeSuccess {
+		t.Errorf("Expected a partial answer for a known name, got %s", dns.RcodeToString[rcode])
+	}
+	// absence can't be asserted until all resources are synced
+	if rcode := query("unknown.example.com."); rcode != dns.RcodeServerFailure {
+		t.Errorf("Expected SERVFAIL for an unknown name before sync, got %s", dns.RcodeToString[rcode])
+	}
+
+	gw.Controller.synced.Store(true)
+	if rcode := query("unknown.example.com."); rcode != dns.RcodeNameError {
+		t.Errorf("Expected NXDOMAIN for an unknown name after sync, got %s", dns.RcodeToString[rcode])
+	}
+}
+
+func TestPluginZoneConfig(t *testing.T) {
+
+	ctrl := newSyncedController()
+
+	gw := newGateway()
+	gw.Zones = []string{"example.com.", "internal.example.com."}
+	gw.Next = test.NextHandler(dns.RcodeSuccess, nil)
+	gw.ExternalAddrFunc = gw.SelfAddress
+	gw.Controller = ctrl
+	internal := gw.zoneConfig
+	internal.ttlLow = 10
+	internal.updateResources([]string{"Service"})
+	gw.zoneConfigs["internal.example.com."] = &internal
+	setupLookupFuncs()
+
+	ctx := context.TODO()
+	for i, tc := range testsZoneConfig {
+		r := tc.Msg()
+		w := dnstest.NewRecorder(&test.ResponseWriter{})
+
+		_, err := gw.ServeDNS(ctx, w, r)
+		if err != tc.Error {
+			t.Errorf("Test %d expected no error, got %v", i, err)
+			return
+		}
+
+		resp := w.Msg
+		if resp == nil {
+			t.Fatalf("Test %d, got nil message and no error for %q", i, r.Question[0].Name)
+		}
+		if err = test.SortAndCheck(resp, tc); err != nil {
+			t.Errorf("Test %d failed with error: %v", i, err)
+		}
+	}
+}
+
+func TestPluginMerge(t *testing.T) {
+
+	ctrl := newSyncedController()
+
+	gw := newGateway()
+	gw.Zones = []string{"example.com."}
+	gw.Next = test.NextHandler(dns.RcodeSuccess, nil)
+	gw.ExternalAddrFunc = gw.SelfAddress
+	gw.Controller = ctrl
+	gw.merge = true
+	setupLookupFuncs()
+
+	tc := test.Case{
+		Qname: "shadow.example.com.", Qtype: dns.TypeA, Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.A("shadow.example.com.	60	IN	A	192.0.0.4"),
+			test.A("shadow.example.com.	60	IN	A	192.0.2.4"),
+			test.A("shadow.example.com.	60	IN	A	192.0.3.4"),
+		},
+	}
+
+	r := tc.Msg()
+	w := dnstest.NewRecorder(&test.ResponseWriter{})
+	if _, err := gw.ServeDNS(context.TODO(), w, r); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := test.SortAndCheck(w.Msg, tc); err != nil {
+		t.Errorf("Merged lookup failed with error: %v", err)
+	}
+
+	// a zero weight withdraws a resource, the only other weighted kind must then always come first
+	gw.weights = map[string]int{"Ingress": 0, "VirtualServer": 1000000}
+	for i := 0; i < 10; i++ {
+		ordered := gw.orderByWeight([]lookupResult{
+			{kind: "HTTPRoute", addrs: []netip.Addr{netip.MustParseAddr("192.0.2.4")}},
+			{kind: "VirtualServer", addrs: []netip.Addr{netip.MustParseAddr("192.0.3.4")}},
+			{kind: "Ingress", addrs: []netip.Addr{netip.MustParseAddr("192.0.0.4")}},
+		})
+		if len(ordered) != 2 {
+			t.Fatalf("Expected Ingress to be dropped, got %v", ordered)
+		}
+		if ordered[0].kind != "VirtualServer" {
+			t.Errorf("Expected the heavily weighted VirtualServer first, got %v", ordered)
+		}
+	}
+}
+
+func TestAnswerTTL(t *testing.T) {
+	gw := newGateway()
+	gw.ttlLow = 60
+	gw.ttlMin = 10
+	gw.ttlMax = 300
+
+	annotated := func(ttl string) lookupResult {
+		return lookupResult{kind: "Ingress", object: &networking.Ingress{
+			ObjectMeta: meta.ObjectMeta{Annotations: map[string]string{"coredns.io/ttl": ttl}},
+		}}
+	}
+
+	tests := []struct {
+		results  []lookupResult
+		expected uint32
+	}{
+		{nil, 60},
+		{[]lookupResult{{kind: "Service"}}, 60},
+		{[]lookupResult{annotated("120")}, 120},
+		{[]lookupResult{annotated("1")}, 10},
+		{[]lookupResult{annotated("86400")}, 300},
+		{[]lookupResult{annotated("120"), {kind: "Service"}}, 60},
+		{[]lookupResult{annotated("30"), annotated("45")}, 30},
+	}
+
+	for i, test := range tests {
+		if ttl := gw.answerTTL(test.results); ttl != test.expected {
+			t.Errorf("Test %d: expected ttl %d, got %d", i, test.expected, ttl)
+		}
+	}
+}
+
+func TestPluginCNAME(t *testing.T) {
+
+	ctrl := newSyncedController()
+
+	gw := newGateway()
+	gw.Zones = []string{"example.com."}
+	gw.Next = test.NextHandler(dns.RcodeSuccess, nil)
+	gw.ExternalAddrFunc = gw.SelfAddress
+	gw.Controller = ctrl
+	gw.Resources = []*resourceWithIndex{
+		{name: "Ingress", lookup: func(keys []string) (results []lookupResult) {
+			for _, key := range keys {
+				if key == "cdn.example.com" {
+					results = append(results, lookupResult{kind: "Ingress", cname: "edge.cdn.example.net."})
+				}
+			}
+			return
+		}},
+	}
+
+	tests := []test.Case{
+		{
+			Qname: "cdn.example.com.", Qtype: dns.TypeA, Rcode: dns.RcodeSuccess,
+			Answer: []dns.RR{
+				test.CNAME("cdn.example.com.	60	IN	CNAME	edge.cdn.example.net."),
+			},
+		},
+		{
+			Qname: "cdn.example.com.", Qtype: dns.TypeAAAA, Rcode: dns.RcodeSuccess,
+			Answer: []dns.RR{
+				test.CNAME("cdn.example.com.	60	IN	CNAME	edge.cdn.example.net."),
+			},
+		},
+	}
+
+	for i, tc := range tests {
+		r := tc.Msg()
+		w := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := gw.ServeDNS(context.TODO(), w, r); err != nil {
+			t.Fatalf("Test %d expected no error, got %v", i, err)
+		}
+		if err := test.SortAndCheck(w.Msg, tc); err != nil {
+			t.Errorf("Test %d failed with error: %v", i, err)
+		}
+	}
+}
+
+var testsZoneConfig = []test.Case{
+	// Service in the zone with overridden TTL | Test 0
+	{
+		Qname: "svc1.ns1.internal.example.com.", Qtype: dns.TypeA, Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.A("svc1.ns1.internal.example.com.	10	IN	A	192.0.1.1"),
+		},
+	},
+	// Ingress is not a resource of the zone | Test 1
+	{
+		Qname: "domain.internal.example.com.", Qtype: dns.TypeA, Rcode: dns.RcodeNameError,
+		Ns: []dns.RR{
+			test.SOA("internal.example.com.	60	IN	SOA	dns1.kube-system.internal.example.com. hostmaster.internal.example.com. 1499347823 7200 1800 86400 5"),
+		},
+	},
+	// Plugin-wide settings still apply to other zones | Test 2
+	{
+		Qname: "domain.example.com.", Qtype: dns.TypeA, Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.A("domain.example.com.	60	IN	A	192.0.0.1"),
+		},
+	},
+}
+
 var tests = []test.Case{
 	// Existing Service IPv4 | Test 0
 	{
@@ -141,10 +368,10 @@ var tests = []test.Case{
 			test.SOA("example.com.	60	IN	SOA	dns1.kube-system.example.com. hostmaster.example.com. 1499347823 7200 1800 86400 5"),
 		},
 	},
-	// SOA for the existing domain | Test 5
+	// SOA for the existing domain, only the apex has one | Test 5
 	{
 		Qname: "domain.example.com.", Qtype: dns.TypeSOA, Rcode: dns.RcodeSuccess,
-		Answer: []dns.RR{
+		Ns: []dns.RR{
 			test.SOA("example.com.	60	IN	SOA	dns1.kube-system.example.com. hostmaster.example.com. 1499347823 7200 1800 86400 5"),
 		},
 	},
@@ -155,9 +382,9 @@ var tests = []test.Case{
 			test.SOA("example.com.	60	IN	SOA	dns1.kube-system.example.com. hostmaster.example.com. 1499347823 7200 1800 86400 5"),
 		},
 	},
-	// Real service, wrong query type | Test 7
+	// Service with no public addresses, other query type | Test 7
 	{
-		Qname: "svc3.ns1.example.com.", Qtype: dns.TypeCNAME, Rcode: dns.RcodeSuccess,
+		Qname: "svc3.ns1.example.com.", Qtype: dns.TypeCNAME, Rcode: dns.RcodeNameError,
 		Ns: []dns.RR{
 			test.SOA("example.com.	60	IN	SOA	dns1.kube-system.example.com. hostmaster.example.com. 1499347823 7200 1800 86400 5"),
 		},
@@ -235,6 +462,41 @@ var tests = []test.Case{
 			test.A("dns1.kube-system.example.com.	60	IN	A	192.0.1.53"),
 		},
 	},
+	// Existing Service, query type without records | Test 18
+	{
+		Qname: "svc1.ns1.example.com.", Qtype: dns.TypeMX, Rcode: dns.RcodeSuccess,
+		Ns: []dns.RR{
+			test.SOA("example.com.	60	IN	SOA	dns1.kube-system.example.com. hostmaster.example.com. 1499347823 7200 1800 86400 5"),
+		},
+	},
+	// Non-existing name, query type without records | Test 19
+	{
+		Qname: "svcX.ns1.example.com.", Qtype: dns.TypeTXT, Rcode: dns.RcodeNameError,
+		Ns: []dns.RR{
+			test.SOA("example.com.	60	IN	SOA	dns1.kube-system.example.com. hostmaster.example.com. 1499347823 7200 1800 86400 5"),
+		},
+	},
+	// Empty non-terminal, with or without the snapshot | Test 20
+	{
+		Qname: "ns1.example.com.", Qtype: dns.TypeA, Rcode: dns.RcodeSuccess,
+		Ns: []dns.RR{
+			test.SOA("example.com.	60	IN	SOA	dns1.kube-system.example.com. hostmaster.example.com. 1499347823 7200 1800 86400 5"),
+		},
+	},
+	// Parent of the nameserver names | Test 21
+	{
+		Qname: "kube-system.example.com.", Qtype: dns.TypeA, Rcode: dns.RcodeSuccess,
+		Ns: []dns.RR{
+			test.SOA("example.com.	60	IN	SOA	dns1.kube-system.example.com. hostmaster.example.com. 1499347823 7200 1800 86400 5"),
+		},
+	},
+	// NS below the apex | Test 22
+	{
+		Qname: "domain.example.com.", Qtype: dns.TypeNS, Rcode: dns.RcodeSuccess,
+		Ns: []dns.RR{
+			test.SOA("example.com.	60	IN	SOA	dns1.kube-system.example.com. hostmaster.example.com. 1499347823 7200 1800 86400 5"),
+		},
+	},
 }
 
 var testsFallthrough = []FallthroughCase{
@@ -272,24 +534,25 @@ var testServiceIndexes = map[string][]netip.Addr{
 	"dns1.kube-system": {netip.MustParseAddr("192.0.1.53")},
 }
 
-func testServiceLookup(keys []string) (results []netip.Addr) {
+func testServiceLookup(keys []string) (results []lookupResult) {
 	for _, key := range keys {
-		results = append(results, testServiceIndexes[strings.ToLower(key)]...)
+		results = append(results, lookupResult{kind: "Service", addrs: testServiceIndexes[strings.ToLower(key)]})
 	}
 	return results
 }
 
 var testIngressIndexes = map[string][]netip.Addr{
-	"domain.example.com":    {netip.MustParseAddr("192.0.0.1")},
-	"svc2.ns1.example.com":  {netip.MustParseAddr("192.0.0.2")},
-	"example.com":           {netip.MustParseAddr("192.0.0.3")},
-	"shadow.example.com":    {netip.MustParseAddr("192.0.0.4")},
-	"shadow-vs.example.com": {netip.MustParseAddr("192.0.0.5")},
+	"domain.example.com":          {netip.MustParseAddr("192.0.0.1")},
+	"svc2.ns1.example.com":        {netip.MustParseAddr("192.0.0.2")},
+	"example.com":                 {netip.MustParseAddr("192.0.0.3")},
+	"shadow.example.com":          {netip.MustParseAddr("192.0.0.4")},
+	"shadow-vs.example.com":       {netip.MustParseAddr("192.0.0.5")},
+	"domain.internal.example.com": {netip.MustParseAddr("192.0.0.6")},
 }
 
-func testIngressLookup(keys []string) (results []netip.Addr) {
+func testIngressLookup(keys []string) (results []lookupResult) {
 	for _, key := range keys {
-		results = append(results, testIngressIndexes[strings.ToLower(key)]...)
+		results = append(results, lookupResult{kind: "Ingress", addrs: testIngressIndexes[strings.ToLower(key)]})
 	}
 	return results
 }
@@ -300,9 +563,9 @@ var testVirtualServerIndexes = map[string][]netip.Addr{
 	"shadow-vs.example.com": {netip.MustParseAddr("192.0.3.5")},
 }
 
-func testVirtualServerLookup(keys []string) (results []netip.Addr) {
+func testVirtualServerLookup(keys []string) (results []lookupResult) {
 	for _, key := range keys {
-		results = append(results, testVirtualServerIndexes[strings.ToLower(key)]...)
+		results = append(results, lookupResult{kind: "VirtualServer", addrs: testVirtualServerIndexes[strings.ToLower(key)]})
 	}
 	return results
 }
@@ -312,30 +575,63 @@ var testRouteIndexes = map[string][]netip.Addr{
 	"shadow.example.com":    {netip.MustParseAddr("192.0.2.4")},
 }
 
-func testRouteLookup(keys []string) (results []netip.Addr) {
+func testRouteLookup(keys []string) (results []lookupResult) {
 	for _, key := range keys {
-		results = append(results, testRouteIndexes[strings.ToLower(key)]...)
+		results = append(results, lookupResult{kind: "HTTPRoute", addrs: testRouteIndexes[strings.ToLower(key)]})
 	}
 	return results
 }
 
+// clearLookupFuncs unbinds the lookups of all resources
+func clearLookupFuncs() {
+	for _, resource := range orderedResources {
+		resource.clusters = nil
+		resource.lookup, resource.keys = noop, nil
+	}
+}
+
 func setupLookupFuncs() {
+	for _, resource := range orderedResources {
+		resource.clusters = nil
+	}
 	if resource := lookupResource("Ingress"); resource != nil {
 		resource.lookup = testIngressLookup
+		resource.keys = testKeys(testIngressIndexes)
 	}
 	if resource := lookupResource("Service"); resource != nil {
 		resource.lookup = testServiceLookup
+		resource.keys = testKeys(testServiceIndexes)
 	}
 	if resource := lookupResource("VirtualServer"); resource != nil {
 		resource.lookup = testVirtualServerLookup
+		resource.keys = testKeys(testVirtualServerIndexes)
 	}
 	if resource := lookupResource("HTTPRoute"); resource != nil {
 		resource.lookup = testRouteLookup
+		resource.keys = testKeys(testRouteIndexes)
 	}
 	if resource := lookupResource("TLSRoute"); resource != nil {
 		resource.lookup = testRouteLookup
+		resource.keys = testKeys(testRouteIndexes)
 	}
 	if resource := lookupResource("GRPCRoute"); resource != nil {
 		resource.lookup = testRouteLookup
+		resource.keys = testKeys(testRouteIndexes)
+	}
+}
+
+// newSyncedController returns a controller whose caches count as synced
+func newSyncedController() *KubeController {
+	ctrl := &KubeController{}
+	ctrl.synced.Store(true)
+	return ctrl
+}
+
+func testKeys(indexes map[string][]netip.Addr) func() []string {
+	return func() (keys []string) {
+		for key := range indexes {
+			keys = append(keys, key)
+		}
+		return keys
 	}
 }
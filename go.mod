@@ -7,6 +7,7 @@ require (
 	github.com/coredns/coredns v1.11.3
 	github.com/miekg/dns v1.1.58
 	github.com/nginxinc/kubernetes-ingress v1.12.5
+	github.com/prometheus/client_golang v1.19.0
 	k8s.io/api v0.29.3
 	k8s.io/apimachinery v0.29.3
 	k8s.io/client-go v0.29.3
@@ -93,7 +94,6 @@ require (
 	github.com/outcaste-io/ristretto v0.2.3 // indirect
 	github.com/philhofer/fwd v1.1.2 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
-	github.com/prometheus/client_golang v1.19.0 // indirect
 	github.com/prometheus/client_model v0.6.0 // indirect
 	github.com/prometheus/common v0.53.0 // indirect
 	github.com/prometheus/procfs v0.12.0 // indirect
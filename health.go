@@ -0,0 +1,247 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	core "k8s.io/api/core/v1"
+	discovery "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	gatewayapi_v1 "sigs.k8s.io/gateway-api/apis/v1"
+	gatewayapi_v1alpha2 "sigs.k8s.io/gateway-api/apis/v1alpha2"
+)
+
+const endpointSliceServiceIndex = "endpointSliceService"
+
+// healthMode defines what happens to objects whose backends have no ready endpoints
+type healthMode int
+
+const (
+	healthOff healthMode = iota
+	// healthWithdraw never publishes unhealthy objects
+	healthWithdraw
+	// healthDeprioritize only publishes unhealthy objects if no healthy object claims the name
+	healthDeprioritize
+)
+
+func parseHealthMode(args []string) (healthMode, error) {
+	if len(args) != 1 {
+		return healthOff, fmt.Errorf("expected a single health check mode, got %v", args)
+	}
+
+	switch args[0] {
+	case "withdraw":
+		return healthWithdraw, nil
+	case "deprioritize":
+		return healthDeprioritize, nil
+	}
+
+	return healthOff, fmt.Errorf("unknown health check mode '%s'", args[0])
+}
+
+// endpointHealth tracks which Services have ready endpoints, from their EndpointSlices
+type endpointHealth struct {
+	informer cache.SharedIndexInformer
+
+	// ready holds the Services that had ready endpoints when last seen, so that only flips trigger a rebuild
+	mu    sync.Mutex
+	ready map[string]bool
+}
+
+// watchEndpoints adds an informer on EndpointSlices, which is synced along with the other resources
+func (ctrl *KubeController) watchEndpoints(ctx context.Context) {
+	informer := newStrippedInformer(
+		&cache.ListWatch{
+			ListFunc:  endpointSliceLister(ctx, ctrl.client, core.NamespaceAll),
+			WatchFunc: endpointSliceWatcher(ctx, ctrl.client, core.NamespaceAll),
+		},
+		&discovery.EndpointSlice{},
+		defaultResyncPeriod,
+		cache.Indexers{endpointSliceServiceIndex: endpointSliceServiceIndexFunc},
+	)
+	ctrl.endpoints = &endpointHealth{informer: informer, ready: make(map[string]bool)}
+	informer.AddEventHandler(ctrl.readinessHandler())
+	ctrl.register("EndpointSlice", informer)
+}
+
+func endpointSliceLister(ctx context.Context, c kubernetes.Interface, ns string) func(metav1.ListOptions) (runtime.Object, error) {
+	return func(opts metav1.ListOptions) (runtime.Object, error) {
+		return c.DiscoveryV1().EndpointSlices(ns).List(ctx, opts)
+	}
+}
+
+func endpointSliceWatcher(ctx context.Context, c kubernetes.Interface, ns string) func(metav1.ListOptions) (watch.Interface, error) {
+	return func(opts metav1.ListOptions) (watch.Interface, error) {
+		return c.DiscoveryV1().EndpointSlices(ns).Watch(ctx, opts)
+	}
+}
+
+// endpointSliceServiceIndexFunc indexes EndpointSlices by the namespace and name of their Service
+func endpointSliceServiceIndexFunc(obj interface{}) ([]string, error) {
+	slice, ok := obj.(*discovery.EndpointSlice)
+	if !ok {
+		return []string{}, nil
+	}
+	service, ok := slice.Labels[discovery.LabelServiceName]
+	if !ok {
+		return []string{}, nil
+	}
+	return []string{serviceKey(slice.Namespace, service)}, nil
+}
+
+// readinessHandler rebuilds the answers when a Service gains its first ready endpoint or loses its
+// last one. Since routes can share backends, the whole snapshot is rebuilt.
+func (ctrl *KubeController) readinessHandler() cache.ResourceEventHandler {
+	notify := func(obj interface{}) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		keys, _ := endpointSliceServiceIndexFunc(obj)
+		for _, key := range keys {
+			ready := ctrl.serviceReady(key)
+
+			h := ctrl.endpoints
+			h.mu.Lock()
+			last := h.ready[key]
+			if ready {
+				h.ready[key] = true
+			} else {
+				delete(h.ready, key)
+			}
+			h.mu.Unlock()
+
+			if last != ready && ctrl.onChange != nil {
+				log.Debugf("Service %s is now ready: %t", key, ready)
+				ctrl.onChange(nil)
+			}
+		}
+	}
+
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc:    notify,
+		UpdateFunc: func(_, newObj interface{}) { notify(newObj) },
+		DeleteFunc: notify,
+	}
+}
+
+// serviceReady returns true if any EndpointSlice of a Service, keyed by namespace/name, has a
+// ready endpoint. Endpoints without a ready condition count as ready.
+func (ctrl *KubeController) serviceReady(key string) bool {
+	objs, _ := ctrl.endpoints.informer.GetIndexer().ByIndex(endpointSliceServiceIndex, key)
+	for _, obj := range objs {
+		slice, _ := obj.(*discovery.EndpointSlice)
+		for _, endpoint := range slice.Endpoints {
+			if endpoint.Conditions.Ready == nil || *endpoint.Conditions.Ready {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// backendsReady returns true if any Service backend of a route has a ready endpoint. Backends of
+// other kinds can't be checked and count as ready, and so does a route without any backend, e.g.
+// one that only redirects.
+func (ctrl *KubeController) backendsReady(namespace string, refs []gatewayapi_v1.BackendObjectReference) bool {
+	if len(refs) == 0 {
+		return true
+	}
+	for _, ref := range refs {
+		if ref.Group != nil && *ref.Group != "" || ref.Kind != nil && *ref.Kind != "Service" {
+			return true
+		}
+		ns := namespace
+		if ref.Namespace != nil {
+			ns = string(*ref.Namespace)
+		}
+		if ctrl.serviceReady(serviceKey(ns, string(ref.Name))) {
+			return true
+		}
+	}
+	return false
+}
+
+// healthy returns false for Services without any ready endpoint, and for routes whose backends
+// all lack one. Other kinds, and objects of clusters whose endpoints aren't watched, are healthy.
+func (gw *Gateway) healthy(r lookupResult) bool {
+	ctrl := gw.controllerFor(r.cluster)
+	if gw.health == healthOff || ctrl == nil || ctrl.endpoints == nil || r.object == nil {
+		return true
+	}
+
+	var refs []gatewayapi_v1.BackendObjectReference
+	switch obj := r.object.(type) {
+	case *core.Service:
+		return ctrl.serviceReady(serviceKey(obj.Namespace, obj.Name))
+	case *gatewayapi_v1.HTTPRoute:
+		for _, rule := range obj.Spec.Rules {
+			for _, ref := range rule.BackendRefs {
+				refs = append(refs, ref.BackendObjectReference)
+			}
+		}
+	case *gatewayapi_v1alpha2.GRPCRoute:
+		for _, rule := range obj.Spec.Rules {
+			for _, ref := range rule.BackendRefs {
+				refs = append(refs, ref.BackendObjectReference)
+			}
+		}
+	case *gatewayapi_v1alpha2.TLSRoute:
+		for _, rule := range obj.Spec.Rules {
+			for _, ref := range rule.BackendRefs {
+				refs = append(refs, ref.BackendObjectReference)
+			}
+		}
+	default:
+		return true
+	}
+	return ctrl.backendsReady(r.namespace(), refs)
+}
+
+// healthyResults drops the objects whose backends have no ready endpoints. When deprioritized,
+// they are only dropped if a healthy object claims the name as well.
+func (gw *Gateway) healthyResults(results []lookupResult) []lookupResult {
+	if gw.health == healthOff {
+		return results
+	}
+
+	var healthy []lookupResult
+	var claimed bool
+	for _, result := range results {
+		if gw.healthy(result) {
+			healthy = append(healthy, result)
+			claimed = claimed || result.claims()
+		}
+	}
+	if gw.health == healthDeprioritize && !claimed {
+		return results
+	}
+	return healthy
+}
+
+// stripEndpointSlice only keeps the Service an EndpointSlice belongs to and the readiness of its
+// endpoints, the labels are needed by the index
+func stripEndpointSlice(slice *discovery.EndpointSlice) {
+	service, hasService := slice.Labels[discovery.LabelServiceName]
+	stripMeta(&slice.ObjectMeta)
+	if hasService {
+		slice.Labels = map[string]string{discovery.LabelServiceName: service}
+	}
+
+	endpoints := make([]discovery.Endpoint, 0, len(slice.Endpoints))
+	for _, endpoint := range slice.Endpoints {
+		endpoints = append(endpoints, discovery.Endpoint{Conditions: discovery.EndpointConditions{Ready: endpoint.Conditions.Ready}})
+	}
+	slice.Endpoints = endpoints
+	slice.Ports = nil
+}
+
+// serviceKey is the key of a Service in the EndpointSlice index
+func serviceKey(namespace, name string) string {
+	return strings.Join([]string{namespace, name}, "/")
+}
@@ -0,0 +1,137 @@
+package gateway
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	discovery "k8s.io/api/discovery/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	gatewayapi_v1 "sigs.k8s.io/gateway-api/apis/v1"
+)
+
+func TestHealthyResults(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := fake.NewSimpleClientset(
+		testEndpointSlice("ready", true),
+		testEndpointSlice("unready", false),
+	)
+	ctrl := newSyncedController()
+	ctrl.client = client
+	ctrl.watchEndpoints(ctx)
+	changes := make(chan struct{}, 10)
+	ctrl.onChange = func([]string) { changes <- struct{}{} }
+	go ctrl.endpoints.informer.Run(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), ctrl.endpoints.informer.HasSynced) {
+		t.Fatal("Failed to sync EndpointSlices")
+	}
+
+	gw := newGateway()
+	gw.Controller = ctrl
+	gw.health = healthWithdraw
+
+	addrs := []netip.Addr{netip.MustParseAddr("192.0.2.1")}
+	ready := lookupResult{kind: "Service", object: testHealthService("ready"), addrs: addrs}
+	unready := lookupResult{kind: "Service", object: testHealthService("unready"), addrs: addrs}
+	missing := lookupResult{kind: "Service", object: testHealthService("missing"), addrs: addrs}
+	route := lookupResult{kind: "HTTPRoute", object: testHealthRoute("unready", "ready"), addrs: addrs}
+	emptyRoute := lookupResult{kind: "HTTPRoute", object: testHealthRoute(), addrs: addrs}
+	ingress := lookupResult{kind: "Ingress", object: testConflictResult("Ingress", "default", "ing", time.Hour).object, addrs: addrs}
+
+	tests := []struct {
+		mode     healthMode
+		results  []lookupResult
+		expected int
+	}{
+		{healthWithdraw, []lookupResult{ready, unready, missing}, 1},
+		// a route without backends, e.g. a redirect, is healthy
+		{healthWithdraw, []lookupResult{route, emptyRoute, ingress}, 3},
+		{healthWithdraw, []lookupResult{unready}, 0},
+		{healthDeprioritize, []lookupResult{ready, unready}, 1},
+		{healthDeprioritize, []lookupResult{unready, missing}, 2},
+		{healthOff, []lookupResult{unready, missing}, 2},
+	}
+	for i, tc := range tests {
+		gw.health = tc.mode
+		if healthy := gw.healthyResults(tc.results); len(healthy) != tc.expected {
+			t.Errorf("Test %d: expected %d results, got %v", i, tc.expected, healthy)
+		}
+	}
+
+	// the answers are rebuilt once the Service gets a ready endpoint
+	for len(changes) > 0 {
+		<-changes
+	}
+	slice := testEndpointSlice("unready", true)
+	if _, err := client.DiscoveryV1().EndpointSlices("default").Update(ctx, slice, meta.UpdateOptions{}); err != nil {
+		t.Fatalf("Failed to update EndpointSlice: %s", err)
+	}
+	select {
+	case <-changes:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected a rebuild when the Service became ready")
+	}
+	if !ctrl.serviceReady(serviceKey("default", "unready")) {
+		t.Error("Expected the Service to be ready")
+	}
+}
+
+func TestParseHealthMode(t *testing.T) {
+	tests := []struct {
+		args      []string
+		shouldErr bool
+		expected  healthMode
+	}{
+		{[]string{"withdraw"}, false, healthWithdraw},
+		{[]string{"deprioritize"}, false, healthDeprioritize},
+		{[]string{}, true, healthOff},
+		{[]string{"drop"}, true, healthOff},
+		{[]string{"withdraw", "now"}, true, healthOff},
+	}
+
+	for i, test := range tests {
+		mode, err := parseHealthMode(test.args)
+		if test.shouldErr != (err != nil) {
+			t.Errorf("Test %d: expected error %t, got %v", i, test.shouldErr, err)
+			continue
+		}
+		if mode != test.expected {
+			t.Errorf("Test %d: expected mode %d, got %d", i, test.expected, mode)
+		}
+	}
+}
+
+func testEndpointSlice(service string, ready bool) *discovery.EndpointSlice {
+	return &discovery.EndpointSlice{
+		ObjectMeta: meta.ObjectMeta{
+			Name:      service + "-abcde",
+			Namespace: "default",
+			Labels:    map[string]string{discovery.LabelServiceName: service},
+		},
+		Endpoints: []discovery.Endpoint{
+			{Addresses: []string{"10.0.0.1"}, Conditions: discovery.EndpointConditions{Ready: &ready}},
+		},
+	}
+}
+
+func testHealthService(name string) *core.Service {
+	return &core.Service{ObjectMeta: meta.ObjectMeta{Name: name, Namespace: "default"}}
+}
+
+func testHealthRoute(backends ...string) *gatewayapi_v1.HTTPRoute {
+	route := &gatewayapi_v1.HTTPRoute{ObjectMeta: meta.ObjectMeta{Name: "route", Namespace: "default"}}
+	for _, backend := range backends {
+		route.Spec.Rules = append(route.Spec.Rules, gatewayapi_v1.HTTPRouteRule{
+			BackendRefs: []gatewayapi_v1.HTTPBackendRef{{BackendRef: gatewayapi_v1.BackendRef{
+				BackendObjectReference: gatewayapi_v1.BackendObjectReference{Name: gatewayapi_v1.ObjectName(backend)},
+			}}},
+		})
+	}
+	return route
+}
@@ -3,134 +3,156 @@ package gateway
 import (
 	"context"
 	"fmt"
-	"net"
+	"maps"
+	"math"
 	"net/netip"
 	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/miekg/dns"
 	nginx_v1 "github.com/nginxinc/kubernetes-ingress/pkg/apis/configuration/v1"
 	k8s_nginx "github.com/nginxinc/kubernetes-ingress/pkg/client/clientset/versioned"
+	nginxscheme "github.com/nginxinc/kubernetes-ingress/pkg/client/clientset/versioned/scheme"
 	core "k8s.io/api/core/v1"
 	networking "k8s.io/api/networking/v1"
-	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/equality"
 	meta "k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	typedcore "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
 	gatewayapi_v1 "sigs.k8s.io/gateway-api/apis/v1"
 	gatewayapi_v1alpha2 "sigs.k8s.io/gateway-api/apis/v1alpha2"
 	gatewayClient "sigs.k8s.io/gateway-api/pkg/client/clientset/versioned"
+	gatewayscheme "sigs.k8s.io/gateway-api/pkg/client/clientset/versioned/scheme"
 )
 
 const (
 	defaultResyncPeriod              = 0
+	syncPollInterval                 = 100 * time.Millisecond
+	syncLogInterval                  = 100 // polls between logging the resources that aren't synced yet
+	syncTimeout                      = time.Minute
 	ingressHostnameIndex             = "ingressHostname"
 	serviceHostnameIndex             = "serviceHostname"
 	gatewayUniqueIndex               = "gatewayIndex"
+	gatewayHostnameIndex             = "gatewayHostname"
 	httpRouteHostnameIndex           = "httpRouteHostname"
 	tlsRouteHostnameIndex            = "tlsRouteHostname"
 	grpcRouteHostnameIndex           = "grpcRouteHostname"
+	routeParentIndex                 = "routeParent"
 	virtualServerHostnameIndex       = "virtualServerHostname"
 	hostnameAnnotationKey            = "coredns.io/hostname"
 	externalDnsHostnameAnnotationKey = "external-dns.alpha.kubernetes.io/hostname"
+	ttlAnnotationKey                 = "coredns.io/ttl"
+	externalDnsTtlAnnotationKey      = "external-dns.alpha.kubernetes.io/ttl"
+	targetAnnotationKey              = "coredns.io/target"
+	externalDnsTargetAnnotationKey   = "external-dns.alpha.kubernetes.io/target"
+	internalTargetAnnotationKey      = "coredns.io/internal-target"
+	virtualServerValid               = "Valid"
+	invalidHostnameReason            = "InvalidHostname"
 )
 
 // KubeController stores the current runtime configuration and cache
 type KubeController struct {
+	// cluster is the name of the cluster watched by the controller, empty without multiple kubeconfigs
+	cluster     string
 	client      kubernetes.Interface
 	nginxClient k8s_nginx.Interface
 	gwClient    gatewayClient.Interface
-	controllers []cache.SharedIndexInformer
-	hasSynced   bool
+	recorder    record.EventRecorder
+	resolver    *hostResolver
+	// onChange is called with the index keys affected by object changes, nil means all of them
+	onChange  func(keys []string)
+	crdGroups []*crdGroup
+
+	// informers holds the running informer of every resource, to track which ones are synced
+	informersMu sync.RWMutex
+	informers   map[string]cache.SharedIndexInformer
+	// synced is set once the informers that were running at startup have synced
+	synced atomic.Bool
+
+	// failing holds the resources that can't be served, the others are served in degraded mode
+	failingMu sync.Mutex
+	failing   map[string]error
+
+	// subscribers are the plugin instances served by the controller, more than one during a reload
+	subscribersMu sync.Mutex
+	subscribers   map[*Gateway]struct{}
+	broadcaster   record.EventBroadcaster
+	// endpoints is only set when the health of backends is checked
+	endpoints *endpointHealth
 }
 
-func newKubeController(ctx context.Context, c *kubernetes.Clientset, gw *gatewayClient.Clientset, nc *k8s_nginx.Clientset) *KubeController {
+// kubeObject is any Kubernetes object that can publish a hostname
+type kubeObject interface {
+	metav1.Object
+	runtime.Object
+}
+
+func newKubeController(ctx context.Context, cluster string, c kubernetes.Interface, gw gatewayClient.Interface, nc k8s_nginx.Interface, resolver *hostResolver) *KubeController {
 	log.Infof("Building k8s_gateway controller")
 
 	ctrl := &KubeController{
+		cluster:     cluster,
 		client:      c,
 		nginxClient: nc,
 		gwClient:    gw,
+		resolver:    resolver,
 	}
 
-	if existGatewayCRDs(ctx, gw) {
-		gatewayController := cache.NewSharedIndexInformer(
-			&cache.ListWatch{
-				ListFunc:  gatewayLister(ctx, ctrl.gwClient, core.NamespaceAll),
-				WatchFunc: gatewayWatcher(ctx, ctrl.gwClient, core.NamespaceAll),
+	// custom resources are only watched while their CRDs are installed, see syncCRDs
+	if gw != nil {
+		ctrl.crdGroups = append(ctrl.crdGroups, &crdGroup{
+			cluster: cluster,
+			resources: []crdResource{
+				{kind: "Gateway", group: "gateway.networking.k8s.io", probe: func(ctx context.Context) error {
+					_, err := ctrl.gwClient.GatewayV1().Gateways(core.NamespaceAll).List(ctx, metav1.ListOptions{Limit: 1})
+					return err
+				}},
+				{kind: "HTTPRoute", group: "gateway.networking.k8s.io", probe: func(ctx context.Context) error {
+					_, err := ctrl.gwClient.GatewayV1().HTTPRoutes(core.NamespaceAll).List(ctx, metav1.ListOptions{Limit: 1})
+					return err
+				}},
+				{kind: "TLSRoute", group: "gateway.networking.k8s.io", probe: func(ctx context.Context) error {
+					_, err := ctrl.gwClient.GatewayV1alpha2().TLSRoutes(core.NamespaceAll).List(ctx, metav1.ListOptions{Limit: 1})
+					return err
+				}},
+				{kind: "GRPCRoute", group: "gateway.networking.k8s.io", probe: func(ctx context.Context) error {
+					_, err := ctrl.gwClient.GatewayV1alpha2().GRPCRoutes(core.NamespaceAll).List(ctx, metav1.ListOptions{Limit: 1})
+					return err
+				}},
 			},
-			&gatewayapi_v1.Gateway{},
-			defaultResyncPeriod,
-			cache.Indexers{gatewayUniqueIndex: gatewayIndexFunc},
-		)
-		ctrl.controllers = append(ctrl.controllers, gatewayController)
-
-		if resource := lookupResource("HTTPRoute"); resource != nil {
-			httpRouteController := cache.NewSharedIndexInformer(
-				&cache.ListWatch{
-					ListFunc:  httpRouteLister(ctx, ctrl.gwClient, core.NamespaceAll),
-					WatchFunc: httpRouteWatcher(ctx, ctrl.gwClient, core.NamespaceAll),
-				},
-				&gatewayapi_v1.HTTPRoute{},
-				defaultResyncPeriod,
-				cache.Indexers{httpRouteHostnameIndex: httpRouteHostnameIndexFunc},
-			)
-			resource.lookup = lookupHttpRouteIndex(httpRouteController, gatewayController)
-			ctrl.controllers = append(ctrl.controllers, httpRouteController)
-		}
-
-		if resource := lookupResource("TLSRoute"); resource != nil {
-			tlsRouteController := cache.NewSharedIndexInformer(
-				&cache.ListWatch{
-					ListFunc:  tlsRouteLister(ctx, ctrl.gwClient, core.NamespaceAll),
-					WatchFunc: tlsRouteWatcher(ctx, ctrl.gwClient, core.NamespaceAll),
-				},
-				&gatewayapi_v1alpha2.TLSRoute{},
-				defaultResyncPeriod,
-				cache.Indexers{tlsRouteHostnameIndex: tlsRouteHostnameIndexFunc},
-			)
-			resource.lookup = lookupTLSRouteIndex(tlsRouteController, gatewayController)
-			ctrl.controllers = append(ctrl.controllers, tlsRouteController)
-		}
-
-		if resource := lookupResource("GRPCRoute"); resource != nil {
-			grpcRouteController := cache.NewSharedIndexInformer(
-				&cache.ListWatch{
-					ListFunc:  grpcRouteLister(ctx, ctrl.gwClient, core.NamespaceAll),
-					WatchFunc: grpcRouteWatcher(ctx, ctrl.gwClient, core.NamespaceAll),
-				},
-				&gatewayapi_v1alpha2.GRPCRoute{},
-				defaultResyncPeriod,
-				cache.Indexers{grpcRouteHostnameIndex: grpcRouteHostnameIndexFunc},
-			)
-			resource.lookup = lookupGRPCRouteIndex(grpcRouteController, gatewayController)
-			ctrl.controllers = append(ctrl.controllers, grpcRouteController)
-		}
-	}
-
-	if existVirtualServerCRDs(ctx, nc) {
-		if resource := lookupResource("VirtualServer"); resource != nil {
-			virtualServerController := cache.NewSharedIndexInformer(
-				&cache.ListWatch{
-					ListFunc:  virtualServerLister(ctx, ctrl.nginxClient, core.NamespaceAll),
-					WatchFunc: virtualServerWatcher(ctx, ctrl.nginxClient, core.NamespaceAll),
-				},
-				&nginx_v1.VirtualServer{},
-				defaultResyncPeriod,
-				cache.Indexers{virtualServerHostnameIndex: virtualServerHostnameIndexFunc},
-			)
-			resource.lookup = lookupVirtualServerIndex(virtualServerController)
-			ctrl.controllers = append(ctrl.controllers, virtualServerController)
-		}
+			build: ctrl.gatewayInformers,
+		})
+	}
+	if nc != nil {
+		ctrl.crdGroups = append(ctrl.crdGroups, &crdGroup{
+			cluster: cluster,
+			resources: []crdResource{
+				{kind: "VirtualServer", group: "k8s.nginx.org/v1", probe: func(ctx context.Context) error {
+					_, err := ctrl.nginxClient.K8sV1().VirtualServers(core.NamespaceAll).List(ctx, metav1.ListOptions{Limit: 1})
+					return err
+				}},
+			},
+			build: ctrl.virtualServerInformers,
+		})
 	}
 
 	if resource := lookupResource("Ingress"); resource != nil {
-		ingressController := cache.NewSharedIndexInformer(
+		ingressController := newStrippedInformer(
 			&cache.ListWatch{
 				ListFunc:  ingressLister(ctx, ctrl.client, core.NamespaceAll),
 				WatchFunc: ingressWatcher(ctx, ctrl.client, core.NamespaceAll),
@@ -139,12 +161,15 @@ func newKubeController(ctx context.Context, c *kubernetes.Clientset, gw *gateway
 			defaultResyncPeriod,
 			cache.Indexers{ingressHostnameIndex: ingressHostnameIndexFunc},
 		)
-		resource.lookup = lookupIngressIndex(ingressController)
-		ctrl.controllers = append(ctrl.controllers, ingressController)
+		ingressController.AddEventHandler(ctrl.hostnameValidationHandler())
+		ingressController.AddEventHandler(resolver.prefetchHandler())
+		ingressController.AddEventHandler(ctrl.changeHandler(ingressHostnameIndexFunc))
+		resource.setLookup(cluster, ctrl, lookupIngressIndex(ingressController, resolver), indexValues(ingressController, ingressHostnameIndex))
+		ctrl.register(resource.name, ingressController)
 	}
 
 	if resource := lookupResource("Service"); resource != nil {
-		serviceController := cache.NewSharedIndexInformer(
+		serviceController := newStrippedInformer(
 			&cache.ListWatch{
 				ListFunc:  serviceLister(ctx, ctrl.client, core.NamespaceAll),
 				WatchFunc: serviceWatcher(ctx, ctrl.client, core.NamespaceAll),
@@ -153,101 +178,484 @@ func newKubeController(ctx context.Context, c *kubernetes.Clientset, gw *gateway
 			defaultResyncPeriod,
 			cache.Indexers{serviceHostnameIndex: serviceHostnameIndexFunc},
 		)
-		resource.lookup = lookupServiceIndex(serviceController)
-		ctrl.controllers = append(ctrl.controllers, serviceController)
+		serviceController.AddEventHandler(ctrl.hostnameValidationHandler())
+		serviceController.AddEventHandler(resolver.prefetchHandler())
+		serviceController.AddEventHandler(ctrl.changeHandler(serviceHostnameIndexFunc))
+		resource.setLookup(cluster, ctrl, lookupServiceIndex(serviceController, resolver), indexValues(serviceController, serviceHostnameIndex))
+		ctrl.register(resource.name, serviceController)
 	}
 
 	return ctrl
 }
 
-func (ctrl *KubeController) run() {
-	stopCh := make(chan struct{})
-	defer close(stopCh)
+// gatewayInformers builds the informers for the installed Gateway API kinds. Routes are only
+// watched together with Gateways, since they inherit the addresses of their parents.
+func (ctrl *KubeController) gatewayInformers(ctx context.Context, kinds []string) (informers []cache.SharedIndexInformer, bindings []lookupBinding) {
+	if !slices.Contains(kinds, "Gateway") {
+		return nil, nil
+	}
+	resolver := ctrl.resolver
+
+	gatewayController := newStrippedInformer(
+		&cache.ListWatch{
+			ListFunc:  gatewayLister(ctx, ctrl.gwClient, core.NamespaceAll),
+			WatchFunc: gatewayWatcher(ctx, ctrl.gwClient, core.NamespaceAll),
+		},
+		&gatewayapi_v1.Gateway{},
+		defaultResyncPeriod,
+		cache.Indexers{
+			gatewayUniqueIndex:   gatewayIndexFunc,
+			gatewayHostnameIndex: gatewayHostnameIndexFunc,
+		},
+	)
+	gatewayController.AddEventHandler(ctrl.hostnameValidationHandler())
+	gatewayController.AddEventHandler(resolver.prefetchHandler())
+	informers = append(informers, gatewayController)
+	var routes []attachedRoutes
+
+	if resource := lookupResource("Gateway"); resource != nil {
+		bindings = append(bindings, lookupBinding{resource, lookupGatewayIndex(gatewayController, resolver), indexValues(gatewayController, gatewayHostnameIndex), gatewayController})
+	}
 
-	var synced []cache.InformerSynced
+	if resource := lookupResource("HTTPRoute"); resource != nil && slices.Contains(kinds, "HTTPRoute") {
+		httpRouteController := newStrippedInformer(
+			&cache.ListWatch{
+				ListFunc:  httpRouteLister(ctx, ctrl.gwClient, core.NamespaceAll),
+				WatchFunc: httpRouteWatcher(ctx, ctrl.gwClient, core.NamespaceAll),
+			},
+			&gatewayapi_v1.HTTPRoute{},
+			defaultResyncPeriod,
+			cache.Indexers{httpRouteHostnameIndex: httpRouteHostnameIndexFunc, routeParentIndex: routeParentIndexFunc},
+		)
+		httpRouteController.AddEventHandler(ctrl.changeHandler(httpRouteHostnameIndexFunc))
+		routes = append(routes, attachedRoutes{httpRouteController, httpRouteHostnameIndexFunc})
+		bindings = append(bindings, lookupBinding{resource, lookupHttpRouteIndex(httpRouteController, gatewayController, resolver), indexValues(httpRouteController, httpRouteHostnameIndex), httpRouteController})
+		informers = append(informers, httpRouteController)
+	}
+
+	if resource := lookupResource("TLSRoute"); resource != nil && slices.Contains(kinds, "TLSRoute") {
+		tlsRouteController := newStrippedInformer(
+			&cache.ListWatch{
+				ListFunc:  tlsRouteLister(ctx, ctrl.gwClient, core.NamespaceAll),
+				WatchFunc: tlsRouteWatcher(ctx, ctrl.gwClient, core.NamespaceAll),
+			},
+			&gatewayapi_v1alpha2.TLSRoute{},
+			defaultResyncPeriod,
+			cache.Indexers{tlsRouteHostnameIndex: tlsRouteHostnameIndexFunc, routeParentIndex: routeParentIndexFunc},
+		)
+		tlsRouteController.AddEventHandler(ctrl.changeHandler(tlsRouteHostnameIndexFunc))
+		routes = append(routes, attachedRoutes{tlsRouteController, tlsRouteHostnameIndexFunc})
+		bindings = append(bindings, lookupBinding{resource, lookupTLSRouteIndex(tlsRouteController, gatewayController, resolver), indexValues(tlsRouteController, tlsRouteHostnameIndex), tlsRouteController})
+		informers = append(informers, tlsRouteController)
+	}
+
+	if resource := lookupResource("GRPCRoute"); resource != nil && slices.Contains(kinds, "GRPCRoute") {
+		grpcRouteController := newStrippedInformer(
+			&cache.ListWatch{
+				ListFunc:  grpcRouteLister(ctx, ctrl.gwClient, core.NamespaceAll),
+				WatchFunc: grpcRouteWatcher(ctx, ctrl.gwClient, core.NamespaceAll),
+			},
+			&gatewayapi_v1alpha2.GRPCRoute{},
+			defaultResyncPeriod,
+			cache.Indexers{grpcRouteHostnameIndex: grpcRouteHostnameIndexFunc, routeParentIndex: routeParentIndexFunc},
+		)
+		grpcRouteController.AddEventHandler(ctrl.changeHandler(grpcRouteHostnameIndexFunc))
+		routes = append(routes, attachedRoutes{grpcRouteController, grpcRouteHostnameIndexFunc})
+		bindings = append(bindings, lookupBinding{resource, lookupGRPCRouteIndex(grpcRouteController, gatewayController, resolver), indexValues(grpcRouteController, grpcRouteHostnameIndex), grpcRouteController})
+		informers = append(informers, grpcRouteController)
+	}
+
+	// routes inherit the addresses of their gateways, so their names change along with them
+	gatewayController.AddEventHandler(ctrl.gatewayChangeHandler(routes))
+	return informers, bindings
+}
+
+// virtualServerInformers builds the informer for nginxinc's VirtualServers
+func (ctrl *KubeController) virtualServerInformers(ctx context.Context, kinds []string) (informers []cache.SharedIndexInformer, bindings []lookupBinding) {
+	if resource := lookupResource("VirtualServer"); resource != nil && slices.Contains(kinds, "VirtualServer") {
+		virtualServerController := newStrippedInformer(
+			&cache.ListWatch{
+				ListFunc:  virtualServerLister(ctx, ctrl.nginxClient, core.NamespaceAll),
+				WatchFunc: virtualServerWatcher(ctx, ctrl.nginxClient, core.NamespaceAll),
+			},
+			&nginx_v1.VirtualServer{},
+			defaultResyncPeriod,
+			cache.Indexers{virtualServerHostnameIndex: virtualServerHostnameIndexFunc},
+		)
+		virtualServerController.AddEventHandler(ctrl.changeHandler(virtualServerHostnameIndexFunc))
+		bindings = append(bindings, lookupBinding{resource, lookupVirtualServerIndex(virtualServerController), indexValues(virtualServerController, virtualServerHostnameIndex), virtualServerController})
+		informers = append(informers, virtualServerController)
+	}
+
+	return informers, bindings
+}
+
+func (ctrl *KubeController) run(ctx context.Context) {
+	stopCh := ctx.Done()
 
 	log.Infof("Starting k8s_gateway controller")
-	for _, ctrl := range ctrl.controllers {
-		go ctrl.Run(stopCh)
-		synced = append(synced, ctrl.HasSynced)
+	ctrl.informersMu.RLock()
+	for _, informer := range ctrl.informers {
+		go informer.Run(stopCh)
 	}
+	ctrl.informersMu.RUnlock()
+
+	// informers for the CRDs installed at startup are synced before the first answer
+	ok := ctrl.syncCRDs(ctx)
 
 	log.Infof("Waiting for controllers to sync")
-	if !cache.WaitForCacheSync(stopCh, synced...) {
-		ctrl.hasSynced = false
+	if !ctrl.waitForSync(stopCh) {
+		log.Infof("Stopped before all resources were synced")
+		ctrl.stop()
+		return
 	}
 	log.Infof("Synced all required resources")
-	ctrl.hasSynced = true
+	ctrl.synced.Store(true)
+	if ctrl.onChange != nil {
+		ctrl.onChange(nil)
+	}
 
-	<-stopCh
+	// failed checks are retried with a backoff, until all resources can be served again
+	backoff := newCRDBackoff()
+	for {
+		delay := crdCheckInterval
+		if ok {
+			backoff = newCRDBackoff()
+		} else {
+			delay = backoff.Step()
+		}
+
+		select {
+		case <-stopCh:
+			ctrl.stop()
+			return
+		case <-time.After(delay):
+			ok = ctrl.syncCRDs(ctx)
+		}
+	}
+}
+
+// stop clears the lookups bound by the controller once its informers are stopped
+func (ctrl *KubeController) stop() {
+	ctrl.stopCRDs()
+
+	ctrl.informersMu.RLock()
+	defer ctrl.informersMu.RUnlock()
+	for kind := range ctrl.informers {
+		if resource := lookupResource(kind); resource != nil {
+			resource.clearLookup(ctrl.cluster, ctrl)
+		}
+	}
+	log.Infof("Stopped k8s_gateway controller")
+}
+
+// subscribe makes the controller invalidate the answers of a plugin instance on object changes
+func (ctrl *KubeController) subscribe(gw *Gateway) {
+	ctrl.subscribersMu.Lock()
+	defer ctrl.subscribersMu.Unlock()
+	if ctrl.subscribers == nil {
+		ctrl.subscribers = make(map[*Gateway]struct{})
+	}
+	ctrl.subscribers[gw] = struct{}{}
+}
+
+func (ctrl *KubeController) unsubscribe(gw *Gateway) {
+	ctrl.subscribersMu.Lock()
+	defer ctrl.subscribersMu.Unlock()
+	delete(ctrl.subscribers, gw)
+}
+
+// notify passes changes on to all subscribed plugin instances
+func (ctrl *KubeController) notify(keys []string) {
+	ctrl.subscribersMu.Lock()
+	defer ctrl.subscribersMu.Unlock()
+	for gw := range ctrl.subscribers {
+		gw.invalidate(keys)
+	}
+}
+
+// setFailing records whether a resource can be served, a nil error clears the failure
+func (ctrl *KubeController) setFailing(kind string, err error) {
+	ctrl.failingMu.Lock()
+	_, wasFailing := ctrl.failing[kind]
+	if err == nil {
+		if wasFailing {
+			log.Infof("Resuming sync of %s resources", kind)
+			delete(ctrl.failing, kind)
+		}
+		resourceFailing.WithLabelValues(kind, ctrl.cluster).Set(0)
+	} else {
+		if ctrl.failing == nil {
+			ctrl.failing = make(map[string]error)
+		}
+		ctrl.failing[kind] = err
+		resourceFailing.WithLabelValues(kind, ctrl.cluster).Set(1)
+	}
+	ctrl.failingMu.Unlock()
+
+	// the health of a cluster decides which one answers with the failover policy
+	if wasFailing != (err != nil) && ctrl.onChange != nil {
+		ctrl.onChange(nil)
+	}
+}
+
+// Failing returns the resources that can't be served and why
+func (ctrl *KubeController) Failing() map[string]error {
+	ctrl.failingMu.Lock()
+	defer ctrl.failingMu.Unlock()
+	return maps.Clone(ctrl.failing)
 }
 
 // HasSynced returns true if all controllers have been synced
 func (ctrl *KubeController) HasSynced() bool {
-	return ctrl.hasSynced
+	return ctrl.synced.Load()
 }
 
-// RunKubeController kicks off the k8s controllers
-func (gw *Gateway) RunKubeController(ctx context.Context) error {
-	config, err := gw.getClientConfig()
-	if err != nil {
-		return err
+// register adds the informer of a resource to the ones whose sync is tracked
+func (ctrl *KubeController) register(kind string, informer cache.SharedIndexInformer) {
+	ctrl.informersMu.Lock()
+	defer ctrl.informersMu.Unlock()
+	if ctrl.informers == nil {
+		ctrl.informers = make(map[string]cache.SharedIndexInformer)
 	}
+	ctrl.informers[kind] = informer
+	resourceSynced.WithLabelValues(kind, ctrl.cluster).Set(boolToFloat(informer.HasSynced()))
+}
 
-	kubeClient, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		return err
+func (ctrl *KubeController) unregister(kind string) {
+	ctrl.informersMu.Lock()
+	defer ctrl.informersMu.Unlock()
+	delete(ctrl.informers, kind)
+	resourceSynced.DeleteLabelValues(kind, ctrl.cluster)
+}
+
+// Unsynced returns the resources whose informers haven't synced yet
+func (ctrl *KubeController) Unsynced() (kinds []string) {
+	ctrl.informersMu.RLock()
+	defer ctrl.informersMu.RUnlock()
+	for kind, informer := range ctrl.informers {
+		synced := informer.HasSynced()
+		if !synced {
+			kinds = append(kinds, kind)
+		}
+		resourceSynced.WithLabelValues(kind, ctrl.cluster).Set(boolToFloat(synced))
 	}
+	sort.Strings(kinds)
+	return kinds
+}
 
-	nginxClient, err := k8s_nginx.NewForConfig(config)
-	if err != nil {
-		panic(err.Error())
+// waitForSync blocks until all registered informers are synced, periodically logging the ones
+// that are still missing. Resources that take longer than syncTimeout are reported as failing,
+// so that other clusters can be served meanwhile. It returns false if stopped before.
+func (ctrl *KubeController) waitForSync(stopCh <-chan struct{}) bool {
+	ticker := time.NewTicker(syncPollInterval)
+	defer ticker.Stop()
+
+	start := time.Now()
+	var timedOut []string
+	for i := 0; ; i++ {
+		unsynced := ctrl.Unsynced()
+		if len(unsynced) == 0 {
+			for _, kind := range timedOut {
+				ctrl.setFailing(kind, nil)
+			}
+			return true
+		}
+		if i > 0 && i%syncLogInterval == 0 {
+			log.Infof("Still waiting for %v to sync", unsynced)
+		}
+		if timedOut == nil && time.Since(start) > syncTimeout {
+			timedOut = unsynced
+			for _, kind := range timedOut {
+				ctrl.setFailing(kind, fmt.Errorf("not synced within %s", syncTimeout))
+			}
+		}
+
+		select {
+		case <-stopCh:
+			return false
+		case <-ticker.C:
+		}
 	}
+}
 
-	gwAPIClient, err := gatewayClient.NewForConfig(config)
-	if err != nil {
-		return err
+// sharedControllers holds the running controllers by client configuration. Plugin instances with
+// the same configuration, e.g. the old and new instances during a reload, share one set of informers.
+var sharedControllers = struct {
+	sync.Mutex
+	m map[string]*sharedController
+}{m: make(map[string]*sharedController)}
+
+type sharedController struct {
+	ctrl   *KubeController
+	refs   int
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// controllerKey identifies the controllers that can be shared between plugin instances
+func (gw *Gateway) controllerKey(cluster clusterConfig) string {
+	return strings.Join([]string{cluster.name, cluster.file, cluster.context, gw.resolverUpstream, strconv.FormatBool(gw.health != healthOff)}, "|")
+}
+
+// RunKubeController kicks off the k8s controllers of every cluster, or joins the ones already
+// running for the same client configuration. The snapshot of the plugin instance is maintained
+// until ctx is done or StopKubeController is called.
+func (gw *Gateway) RunKubeController(ctx context.Context) error {
+	sharedControllers.Lock()
+	defer sharedControllers.Unlock()
+
+	var ctrls []*KubeController
+	for _, cluster := range gw.clusterConfigs() {
+		key := gw.controllerKey(cluster)
+		shared, ok := sharedControllers.m[key]
+		if !ok {
+			var err error
+			if shared, err = gw.startKubeController(cluster); err != nil {
+				for _, cluster := range gw.clusterConfigs()[:len(ctrls)] {
+					gw.releaseController(cluster)
+				}
+				return err
+			}
+			sharedControllers.m[key] = shared
+		} else {
+			log.Infof("Reusing the running k8s_gateway controller")
+		}
+		shared.refs++
+		ctrls = append(ctrls, shared.ctrl)
 	}
 
-	gw.Controller = newKubeController(ctx, kubeClient, gwAPIClient, nginxClient)
-	go gw.Controller.run()
+	ctx, gw.cancel = context.WithCancel(ctx)
+	gw.Controller, gw.clusterControllers = ctrls[0], ctrls
+	for _, ctrl := range ctrls {
+		ctrl.subscribe(gw)
+	}
+	go gw.runSnapshots(ctx)
+	if gw.publish {
+		go gw.runPublisher(ctx)
+	}
+	// the controllers may already be synced, in which case the snapshot is built right away
+	gw.invalidate(nil)
 
 	return nil
-
 }
 
-func existGatewayCRDs(ctx context.Context, c *gatewayClient.Clientset) bool {
-	_, err := c.GatewayV1().Gateways("").List(ctx, metav1.ListOptions{})
-	return handleCRDCheckError(err, "GatewayAPI", "gateway.networking.k8s.io")
-}
+// StopKubeController stops maintaining the snapshot of the plugin instance and leaves the k8s
+// controllers, which are stopped once no other instance uses them
+func (gw *Gateway) StopKubeController() error {
+	sharedControllers.Lock()
+	defer sharedControllers.Unlock()
 
-func existVirtualServerCRDs(ctx context.Context, c *k8s_nginx.Clientset) bool {
-	_, err := c.K8sV1().VirtualServers("").List(ctx, metav1.ListOptions{})
-	return handleCRDCheckError(err, "VirtualServer", "k8s.nginx.org/v1")
+	if gw.cancel == nil {
+		return nil
+	}
+	gw.cancel()
+	gw.cancel = nil
+
+	for i, cluster := range gw.clusterConfigs() {
+		gw.clusterControllers[i].unsubscribe(gw)
+		gw.releaseController(cluster)
+	}
+	return nil
 }
 
-func handleCRDCheckError(err error, resourceName string, apiGroup string) bool {
-	if meta.IsNoMatchError(err) || runtime.IsNotRegisteredError(err) || apierrors.IsNotFound(err) {
-		log.Infof("%s CRDs are not found. Not syncing %s resources.", resourceName, resourceName)
-		return false
+// releaseController drops a reference to the controller of a cluster and stops it once unused.
+// It must be called with the lock of sharedControllers held.
+func (gw *Gateway) releaseController(cluster clusterConfig) {
+	key := gw.controllerKey(cluster)
+	shared, ok := sharedControllers.m[key]
+	if !ok {
+		return
+	}
+	if shared.refs--; shared.refs > 0 {
+		return
+	}
+	delete(sharedControllers.m, key)
+
+	shared.cancel()
+	<-shared.done
+	if shared.ctrl.broadcaster != nil {
+		shared.ctrl.broadcaster.Shutdown()
 	}
-	if apierrors.IsForbidden(err) {
-		log.Infof("access to `%s` is forbidden, please check RBAC. Not syncing %s resources.", apiGroup, resourceName)
-		return false
+}
+
+// startKubeController builds the clients and starts a new set of k8s controllers for a cluster
+func (gw *Gateway) startKubeController(cluster clusterConfig) (*sharedController, error) {
+	config, err := cluster.clientConfig()
+	if err != nil {
+		return nil, err
 	}
+
+	kubeClient, err := kubernetes.NewForConfig(config)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+
+	// custom resource clients are optional, the plugin keeps serving the other resources without them
+	failing := make(map[string]error)
+	var nginxClient k8s_nginx.Interface
+	if nc, err := k8s_nginx.NewForConfig(config); err != nil {
+		log.Errorf("Failed to build the VirtualServer client, not syncing VirtualServer resources: %s", err)
+		failing["VirtualServer"] = err
+	} else {
+		nginxClient = nc
+	}
+
+	var gwAPIClient gatewayClient.Interface
+	if gc, err := gatewayClient.NewForConfig(config); err != nil {
+		log.Errorf("Failed to build the GatewayAPI client, not syncing GatewayAPI resources: %s", err)
+		for _, kind := range []string{"Gateway", "HTTPRoute", "TLSRoute", "GRPCRoute"} {
+			failing[kind] = err
+		}
+	} else {
+		gwAPIClient = gc
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	shared := &sharedController{cancel: cancel, done: make(chan struct{})}
+
+	resolver := newHostResolver(gw.resolverUpstream)
+	ctrl := newKubeController(ctx, cluster.name, kubeClient, gwAPIClient, nginxClient, resolver)
+	if gw.health != healthOff {
+		ctrl.watchEndpoints(ctx)
 	}
-	return true
+	resolver.onChange = func() { ctrl.notify(nil) }
+	ctrl.broadcaster, ctrl.recorder = newEventRecorder(kubeClient)
+	for kind, err := range failing {
+		ctrl.setFailing(kind, err)
+	}
+	ctrl.onChange = ctrl.notify
+	shared.ctrl = ctrl
+
+	go resolver.run(ctx)
+	go func() {
+		defer close(shared.done)
+		ctrl.run(ctx)
+	}()
+
+	return shared, nil
 }
 
-func (gw *Gateway) getClientConfig() (*rest.Config, error) {
-	if gw.configFile != "" {
+// newEventRecorder builds a recorder that can emit events for all object kinds the plugin watches
+func newEventRecorder(c kubernetes.Interface) (record.EventBroadcaster, record.EventRecorder) {
+	scheme := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(gatewayscheme.AddToScheme(scheme))
+	utilruntime.Must(nginxscheme.AddToScheme(scheme))
+
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcore.EventSinkImpl{Interface: c.CoreV1().Events(core.NamespaceAll)})
+
+	return broadcaster, broadcaster.NewRecorder(scheme, core.EventSource{Component: thisPlugin})
+}
+
+func (cluster clusterConfig) clientConfig() (*rest.Config, error) {
+	if cluster.file != "" {
 		overrides := &clientcmd.ConfigOverrides{}
-		overrides.CurrentContext = gw.configContext
+		overrides.CurrentContext = cluster.context
 
 		config := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
-			&clientcmd.ClientConfigLoadingRules{ExplicitPath: gw.configFile},
+			&clientcmd.ClientConfigLoadingRules{ExplicitPath: cluster.file},
 			overrides,
 		)
 
@@ -350,6 +758,33 @@ func gatewayIndexFunc(obj interface{}) ([]string, error) {
 	return []string{fmt.Sprintf("%s/%s", metaObj.GetNamespace(), metaObj.GetName())}, nil
 }
 
+// routeParentIndexFunc indexes routes by the "namespace/name" of their parent Gateways, as they
+// are looked up by lookupGateways
+func routeParentIndexFunc(obj interface{}) ([]string, error) {
+	var refs []gatewayapi_v1.ParentReference
+	var ns string
+	switch route := obj.(type) {
+	case *gatewayapi_v1.HTTPRoute:
+		refs, ns = route.Spec.ParentRefs, route.Namespace
+	case *gatewayapi_v1alpha2.TLSRoute:
+		refs, ns = route.Spec.ParentRefs, route.Namespace
+	case *gatewayapi_v1alpha2.GRPCRoute:
+		refs, ns = route.Spec.ParentRefs, route.Namespace
+	default:
+		return []string{}, nil
+	}
+
+	parents := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		parentNs := ns
+		if ref.Namespace != nil {
+			parentNs = string(*ref.Namespace)
+		}
+		parents = append(parents, fmt.Sprintf("%s/%s", parentNs, ref.Name))
+	}
+	return parents, nil
+}
+
 func httpRouteHostnameIndexFunc(obj interface{}) ([]string, error) {
 	httpRoute, ok := obj.(*gatewayapi_v1.HTTPRoute)
 	if !ok {
@@ -403,6 +838,25 @@ func ingressHostnameIndexFunc(obj interface{}) ([]string, error) {
 		log.Debugf("Adding index %s for ingress %s", rule.Host, ingress.Name)
 		hostnames = append(hostnames, rule.Host)
 	}
+
+	annotated, _ := annotatedHostnames(ingress)
+	for _, hostname := range annotated {
+		log.Debugf("Adding index %s for ingress %s", hostname, ingress.Name)
+		hostnames = append(hostnames, hostname)
+	}
+	return hostnames, nil
+}
+
+func gatewayHostnameIndexFunc(obj interface{}) ([]string, error) {
+	gateway, ok := obj.(*gatewayapi_v1.Gateway)
+	if !ok {
+		return []string{}, nil
+	}
+
+	hostnames, _ := annotatedHostnames(gateway)
+	for _, hostname := range hostnames {
+		log.Debugf("Adding index %s for gateway %s", hostname, gateway.Name)
+	}
 	return hostnames, nil
 }
 
@@ -416,34 +870,238 @@ func serviceHostnameIndexFunc(obj interface{}) ([]string, error) {
 		return []string{}, nil
 	}
 
-	hostname := service.Name + "." + service.Namespace
-	if annotation, exists := checkServiceAnnotation(hostnameAnnotationKey, service); exists {
-		hostname = annotation
-	} else if annotation, exists := checkServiceAnnotation(externalDnsHostnameAnnotationKey, service); exists {
-		hostname = annotation
+	hostnames, _ := annotatedHostnames(service)
+	if len(hostnames) == 0 {
+		hostnames = []string{service.Name + "." + service.Namespace}
 	}
 
-	log.Debugf("Adding index %s for service %s", hostname, service.Name)
+	for _, hostname := range hostnames {
+		log.Debugf("Adding index %s for service %s", hostname, service.Name)
+	}
 
-	return []string{hostname}, nil
+	return hostnames, nil
 }
 
-func checkServiceAnnotation(annotation string, service *core.Service) (string, bool) {
-	if annotationValue, exists := service.Annotations[annotation]; exists {
-		// checking the hostname length limits
-		if _, ok := dns.IsDomainName(annotationValue); ok {
-			// checking RFC 1123 conformance (same as metadata labels)
-			if valid := isdns1123Hostname(annotationValue); valid {
-				return strings.ToLower(annotationValue), true
-			} else {
-				log.Infof("RFC 1123 conformance failed for FQDN: %s", annotationValue)
+// annotatedHostnames returns the names from the first hostname annotation that has any valid ones.
+// Both annotations accept a comma-separated list, invalid names are returned as errors.
+func annotatedHostnames(obj metav1.Object) (hostnames []string, invalid []error) {
+	for _, key := range []string{hostnameAnnotationKey, externalDnsHostnameAnnotationKey} {
+		value, exists := obj.GetAnnotations()[key]
+		if !exists {
+			continue
+		}
+
+		var valid []string
+		for _, hostname := range strings.Split(value, ",") {
+			hostname = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(hostname), "."))
+			if hostname == "" {
+				continue
+			}
+			if err := checkHostname(hostname); err != nil {
+				invalid = append(invalid, fmt.Errorf("%s: %w", key, err))
+				continue
+			}
+			valid = append(valid, hostname)
+		}
+
+		if len(hostnames) == 0 {
+			hostnames = valid
+		}
+	}
+
+	return hostnames, invalid
+}
+
+func checkHostname(hostname string) error {
+	// checking the hostname length limits
+	if _, ok := dns.IsDomainName(hostname); !ok {
+		return fmt.Errorf("invalid FQDN length: %s", hostname)
+	}
+	// checking RFC 1123 conformance (same as metadata labels)
+	if !isdns1123Hostname(hostname) {
+		return fmt.Errorf("RFC 1123 conformance failed for FQDN: %s", hostname)
+	}
+	return nil
+}
+
+// changeHandler notifies the plugin about the index keys of changed objects, both before and after
+// the change. Without an index func every change is reported as affecting all names.
+func (ctrl *KubeController) changeHandler(indexFunc cache.IndexFunc) cache.ResourceEventHandler {
+	notify := func(objs ...interface{}) {
+		if ctrl.onChange == nil {
+			return
+		}
+		if indexFunc == nil {
+			ctrl.onChange(nil)
+			return
+		}
+
+		keys := []string{}
+		for _, obj := range objs {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			found, _ := indexFunc(obj)
+			keys = append(keys, found...)
+		}
+		ctrl.onChange(keys)
+	}
+
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			notify(obj)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			notify(oldObj, newObj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			notify(obj)
+		},
+	}
+}
+
+// attachedRoutes are the routes of one kind that inherit the addresses of their parent Gateways
+type attachedRoutes struct {
+	informer  cache.SharedIndexInformer
+	indexFunc cache.IndexFunc
+}
+
+// gatewayChangeHandler notifies the names of a Gateway and of the routes attached to it. Updates
+// that don't change anything lookups read, like condition heartbeats or the published annotation
+// written back by the publisher, are ignored.
+func (ctrl *KubeController) gatewayChangeHandler(routes []attachedRoutes) cache.ResourceEventHandler {
+	notify := func(objs ...interface{}) {
+		if ctrl.onChange == nil {
+			return
+		}
+
+		keys := []string{}
+		for _, obj := range objs {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			found, _ := gatewayHostnameIndexFunc(obj)
+			keys = append(keys, found...)
+
+			parent, err := gatewayIndexFunc(obj)
+			if err != nil {
+				continue
+			}
+			for _, attached := range routes {
+				routeObjs, _ := attached.informer.GetIndexer().ByIndex(routeParentIndex, parent[0])
+				for _, route := range routeObjs {
+					found, _ := attached.indexFunc(route)
+					keys = append(keys, found...)
+				}
+			}
+		}
+		ctrl.onChange(keys)
+	}
+
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			notify(obj)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldGateway, oldOk := oldObj.(*gatewayapi_v1.Gateway)
+			newGateway, newOk := newObj.(*gatewayapi_v1.Gateway)
+			if oldOk && newOk && !gatewayLookupChanged(oldGateway, newGateway) {
+				return
+			}
+			notify(oldObj, newObj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			notify(obj)
+		},
+	}
+}
+
+// gatewayLookupChanged returns whether an update changes any field read by the lookups of a
+// Gateway or of its routes
+func gatewayLookupChanged(old, new *gatewayapi_v1.Gateway) bool {
+	withoutPublished := func(annotations map[string]string) map[string]string {
+		annotations = maps.Clone(annotations)
+		delete(annotations, publishedAnnotationKey)
+		return annotations
+	}
+	if !maps.Equal(withoutPublished(old.Annotations), withoutPublished(new.Annotations)) ||
+		!equality.Semantic.DeepEqual(old.Status.Addresses, new.Status.Addresses) ||
+		!old.CreationTimestamp.Equal(&new.CreationTimestamp) ||
+		gatewayProgrammed(old) != gatewayProgrammed(new) ||
+		len(old.Status.Listeners) != len(new.Status.Listeners) {
+		return true
+	}
+	for i, listener := range old.Status.Listeners {
+		section := listener.Name
+		if new.Status.Listeners[i].Name != section || listenerResolved(old, &section) != listenerResolved(new, &section) {
+			return true
+		}
+	}
+	return false
+}
+
+// indexValues lists all keys of an informer index
+func indexValues(ctrl cache.SharedIndexInformer, indexName string) func() []string {
+	return func() []string {
+		return ctrl.GetIndexer().ListIndexFuncValues(indexName)
+	}
+}
+
+// hostnameValidationHandler reports invalid names in the hostname annotations of an object as events
+func (ctrl *KubeController) hostnameValidationHandler() cache.ResourceEventHandler {
+	report := func(obj interface{}) {
+		object, ok := obj.(kubeObject)
+		if !ok {
+			return
+		}
+
+		_, invalid := annotatedHostnames(object)
+		for _, err := range invalid {
+			log.Infof("Ignoring hostname annotation of %s/%s: %s", object.GetNamespace(), object.GetName(), err)
+			if ctrl.recorder != nil {
+				ctrl.recorder.Eventf(object, core.EventTypeWarning, invalidHostnameReason, "Ignoring hostname annotation %s", err)
 			}
-		} else {
-			log.Infof("Invalid FQDN length: %s", annotationValue)
 		}
 	}
 
-	return "", false
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: report,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldMeta, err := meta.Accessor(oldObj)
+			if err != nil {
+				return
+			}
+			newMeta, err := meta.Accessor(newObj)
+			if err != nil {
+				return
+			}
+			for _, key := range []string{hostnameAnnotationKey, externalDnsHostnameAnnotationKey} {
+				if oldMeta.GetAnnotations()[key] != newMeta.GetAnnotations()[key] {
+					report(newObj)
+					return
+				}
+			}
+		},
+	}
+}
+
+// objectTTL returns the TTL set in the object annotations, either in seconds or as a duration
+func objectTTL(obj metav1.Object) (uint32, bool) {
+	for _, key := range []string{ttlAnnotationKey, externalDnsTtlAnnotationKey} {
+		value, exists := obj.GetAnnotations()[key]
+		if !exists {
+			continue
+		}
+
+		if seconds, err := strconv.ParseUint(value, 10, 32); err == nil {
+			return uint32(seconds), true
+		}
+		if duration, err := time.ParseDuration(value); err == nil && duration >= 0 && duration.Seconds() <= math.MaxUint32 {
+			return uint32(duration.Seconds()), true
+		}
+		log.Debugf("Ignoring invalid TTL annotation %s=%s on %s/%s", key, value, obj.GetNamespace(), obj.GetName())
+	}
+	return 0, false
 }
 
 func virtualServerHostnameIndexFunc(obj interface{}) ([]string, error) {
@@ -457,8 +1115,8 @@ func virtualServerHostnameIndexFunc(obj interface{}) ([]string, error) {
 	return []string{virtualServer.Spec.Host}, nil
 }
 
-func lookupServiceIndex(ctrl cache.SharedIndexInformer) func([]string) []netip.Addr {
-	return func(indexKeys []string) (result []netip.Addr) {
+func lookupServiceIndex(ctrl cache.SharedIndexInformer, resolver *hostResolver) lookupFunc {
+	return func(indexKeys []string) (result []lookupResult) {
 		var objs []interface{}
 		for _, key := range indexKeys {
 			obj, _ := ctrl.GetIndexer().ByIndex(serviceHostnameIndex, strings.ToLower(key))
@@ -467,23 +1125,68 @@ func lookupServiceIndex(ctrl cache.SharedIndexInformer) func([]string) []netip.A
 		log.Debugf("Found %d matching Service objects", len(objs))
 		for _, obj := range objs {
 			service, _ := obj.(*core.Service)
+			found := lookupResult{kind: "Service", object: service}
+			found.internal, found.internalCNAME = serviceInternalTarget(service)
+
+			if addrs, cname, ok := annotatedTarget(service); ok {
+				found.addrs, found.cname = addrs, cname
+				result = append(result, found)
+				continue
+			}
 
 			if len(service.Spec.ExternalIPs) > 0 {
 				for _, ip := range service.Spec.ExternalIPs {
-					result = append(result, netip.MustParseAddr(ip))
+					addr, err := netip.ParseAddr(ip)
+					if err != nil {
+						continue
+					}
+					found.addrs = append(found.addrs, addr)
 				}
 				// in case externalIPs are defined, ignoring status field completely
-				return
+				result = append(result, found)
+				continue
 			}
 
-			result = append(result, fetchServiceLoadBalancerIPs(service.Status.LoadBalancer.Ingress)...)
+			found.addrs = fetchServiceLoadBalancerIPs(service.Status.LoadBalancer.Ingress, resolver)
+			result = append(result, found)
 		}
 		return
 	}
 }
 
-func lookupVirtualServerIndex(ctrl cache.SharedIndexInformer) func([]string) []netip.Addr {
-	return func(indexKeys []string) (result []netip.Addr) {
+// serviceInternalTarget returns the addresses of a Service in the internal view, its ClusterIPs
+// unless the internal target annotation is set
+func serviceInternalTarget(service *core.Service) ([]netip.Addr, string) {
+	if addrs, cname, ok := internalTarget(service); ok {
+		return addrs, cname
+	}
+
+	var addrs []netip.Addr
+	for _, ip := range service.Spec.ClusterIPs {
+		// headless Services have no ClusterIP
+		if addr, err := netip.ParseAddr(ip); err == nil {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs, ""
+}
+
+// servesFamily returns false for addresses of an IP family missing from the ipFamilies of a
+// Service. Other kinds, and Services whose families aren't known, serve both.
+func servesFamily(obj kubeObject, addr netip.Addr) bool {
+	service, ok := obj.(*core.Service)
+	if !ok || len(service.Spec.IPFamilies) == 0 {
+		return true
+	}
+	family := core.IPv4Protocol
+	if addr.Unmap().Is6() {
+		family = core.IPv6Protocol
+	}
+	return slices.Contains(service.Spec.IPFamilies, family)
+}
+
+func lookupVirtualServerIndex(ctrl cache.SharedIndexInformer) lookupFunc {
+	return func(indexKeys []string) (result []lookupResult) {
 		var objs []interface{}
 		for _, key := range indexKeys {
 			obj, _ := ctrl.GetIndexer().ByIndex(virtualServerHostnameIndex, strings.ToLower(key))
@@ -492,21 +1195,34 @@ func lookupVirtualServerIndex(ctrl cache.SharedIndexInformer) func([]string) []n
 		log.Debugf("Found %d matching VirtualServer objects", len(objs))
 		for _, obj := range objs {
 			virtualServer, _ := obj.(*nginx_v1.VirtualServer)
+			if virtualServer.Status.State != virtualServerValid {
+				log.Debugf("Skipping VirtualServer %s/%s in state %q", virtualServer.Namespace, virtualServer.Name, virtualServer.Status.State)
+				continue
+			}
+			found := lookupResult{kind: "VirtualServer", object: virtualServer}
+			found.internal, found.internalCNAME, _ = internalTarget(virtualServer)
+
+			if addrs, cname, ok := annotatedTarget(virtualServer); ok {
+				found.addrs, found.cname = addrs, cname
+				result = append(result, found)
+				continue
+			}
 
 			for _, endpoint := range virtualServer.Status.ExternalEndpoints {
 				addr, err := netip.ParseAddr(endpoint.IP)
 				if err != nil {
 					continue
 				}
-				result = append(result, addr)
+				found.addrs = append(found.addrs, addr)
 			}
+			result = append(result, found)
 		}
 		return
 	}
 }
 
-func lookupHttpRouteIndex(http, gw cache.SharedIndexInformer) func([]string) []netip.Addr {
-	return func(indexKeys []string) (result []netip.Addr) {
+func lookupHttpRouteIndex(http, gw cache.SharedIndexInformer, resolver *hostResolver) lookupFunc {
+	return func(indexKeys []string) (result []lookupResult) {
 		var objs []interface{}
 		for _, key := range indexKeys {
 			obj, _ := http.GetIndexer().ByIndex(httpRouteHostnameIndex, strings.ToLower(key))
@@ -516,14 +1232,16 @@ func lookupHttpRouteIndex(http, gw cache.SharedIndexInformer) func([]string) []n
 
 		for _, obj := range objs {
 			httpRoute, _ := obj.(*gatewayapi_v1.HTTPRoute)
-			result = append(result, lookupGateways(gw, httpRoute.Spec.ParentRefs, httpRoute.Namespace)...)
+			found := lookupGateways(gw, httpRoute.Spec.ParentRefs, httpRoute.Namespace, resolver)
+			found.kind, found.object = "HTTPRoute", httpRoute
+			result = append(result, found)
 		}
 		return
 	}
 }
 
-func lookupTLSRouteIndex(tls, gw cache.SharedIndexInformer) func([]string) []netip.Addr {
-	return func(indexKeys []string) (result []netip.Addr) {
+func lookupTLSRouteIndex(tls, gw cache.SharedIndexInformer, resolver *hostResolver) lookupFunc {
+	return func(indexKeys []string) (result []lookupResult) {
 		var objs []interface{}
 		for _, key := range indexKeys {
 			obj, _ := tls.GetIndexer().ByIndex(tlsRouteHostnameIndex, strings.ToLower(key))
@@ -533,14 +1251,16 @@ func lookupTLSRouteIndex(tls, gw cache.SharedIndexInformer) func([]string) []net
 
 		for _, obj := range objs {
 			tlsRoute, _ := obj.(*gatewayapi_v1alpha2.TLSRoute)
-			result = append(result, lookupGateways(gw, tlsRoute.Spec.ParentRefs, tlsRoute.Namespace)...)
+			found := lookupGateways(gw, tlsRoute.Spec.ParentRefs, tlsRoute.Namespace, resolver)
+			found.kind, found.object = "TLSRoute", tlsRoute
+			result = append(result, found)
 		}
 		return
 	}
 }
 
-func lookupGRPCRouteIndex(grpc, gw cache.SharedIndexInformer) func([]string) []netip.Addr {
-	return func(indexKeys []string) (result []netip.Addr) {
+func lookupGRPCRouteIndex(grpc, gw cache.SharedIndexInformer, resolver *hostResolver) lookupFunc {
+	return func(indexKeys []string) (result []lookupResult) {
 		var objs []interface{}
 		for _, key := range indexKeys {
 			obj, _ := grpc.GetIndexer().ByIndex(grpcRouteHostnameIndex, strings.ToLower(key))
@@ -550,13 +1270,17 @@ func lookupGRPCRouteIndex(grpc, gw cache.SharedIndexInformer) func([]string) []n
 
 		for _, obj := range objs {
 			grpcRoute, _ := obj.(*gatewayapi_v1alpha2.GRPCRoute)
-			result = append(result, lookupGateways(gw, grpcRoute.Spec.ParentRefs, grpcRoute.Namespace)...)
+			found := lookupGateways(gw, grpcRoute.Spec.ParentRefs, grpcRoute.Namespace, resolver)
+			found.kind, found.object = "GRPCRoute", grpcRoute
+			result = append(result, found)
 		}
 		return
 	}
 }
 
-func lookupGateways(gw cache.SharedIndexInformer, refs []gatewayapi_v1.ParentReference, ns string) (result []netip.Addr) {
+// lookupGateways returns the addresses of all parent Gateways, or the first CNAME target if none
+// have addresses, in both the default and the internal view
+func lookupGateways(gw cache.SharedIndexInformer, refs []gatewayapi_v1.ParentReference, ns string, resolver *hostResolver) (found lookupResult) {
 	for _, gwRef := range refs {
 
 		if gwRef.Namespace != nil {
@@ -569,14 +1293,149 @@ func lookupGateways(gw cache.SharedIndexInformer, refs []gatewayapi_v1.ParentRef
 
 		for _, gwObj := range gwObjs {
 			gw, _ := gwObj.(*gatewayapi_v1.Gateway)
-			result = append(result, fetchGatewayIPs(gw)...)
+			if !gatewayProgrammed(gw) || !listenerResolved(gw, gwRef.SectionName) {
+				log.Debugf("Skipping Gateway %s, which isn't programmed or whose listener has unresolved refs", gwKey)
+				continue
+			}
+			addrs, cname := gatewayAddresses(gw, resolver)
+			found.addrs = append(found.addrs, addrs...)
+			if found.cname == "" {
+				found.cname = cname
+			}
+
+			internal, cname, _ := internalTarget(gw)
+			found.internal = append(found.internal, internal...)
+			if found.internalCNAME == "" {
+				found.internalCNAME = cname
+			}
+
+			// routes are in the regions of their gateways
+			if region := objectRegion(gw); region != "" {
+				if found.regions == nil {
+					found.regions = make(map[netip.Addr]string)
+				}
+				for _, addr := range addrs {
+					found.regions[addr] = region
+				}
+				for _, addr := range internal {
+					found.regions[addr] = region
+				}
+			}
 		}
 	}
+	if len(found.addrs) > 0 {
+		found.cname = ""
+	}
+	if len(found.internal) > 0 {
+		found.internalCNAME = ""
+	}
 	return
 }
 
-func lookupIngressIndex(ctrl cache.SharedIndexInformer) func([]string) []netip.Addr {
-	return func(indexKeys []string) (result []netip.Addr) {
+// gatewayProgrammed returns false if the Programmed condition of a Gateway is False, a Gateway
+// without the condition is assumed to be programmed
+func gatewayProgrammed(gw *gatewayapi_v1.Gateway) bool {
+	return !meta.IsStatusConditionFalse(gw.Status.Conditions, string(gatewayapi_v1.GatewayConditionProgrammed))
+}
+
+// listenerResolved returns false if the listener a route attaches to has unresolved refs. A route
+// without a section name attaches to every listener, and is served as long as one is resolved.
+func listenerResolved(gw *gatewayapi_v1.Gateway, section *gatewayapi_v1.SectionName) bool {
+	var found bool
+	for _, listener := range gw.Status.Listeners {
+		if section != nil && listener.Name != *section {
+			continue
+		}
+		found = true
+		if !meta.IsStatusConditionFalse(listener.Conditions, string(gatewayapi_v1.ListenerConditionResolvedRefs)) {
+			return true
+		}
+	}
+	// listeners without a status yet are assumed to be resolved
+	return !found
+}
+
+// gatewayAddresses returns the addresses of a Gateway, honouring its target annotations
+func gatewayAddresses(gw *gatewayapi_v1.Gateway, resolver *hostResolver) ([]netip.Addr, string) {
+	if addrs, cname, ok := annotatedTarget(gw); ok {
+		return addrs, cname
+	}
+	return fetchGatewayIPs(gw, resolver), ""
+}
+
+// annotatedTarget returns the addresses requested by the target annotations, which replace the ones
+// from the object status. A comma-separated list of IPs is published as is, otherwise the first
+// valid hostname is published as a CNAME.
+func annotatedTarget(obj metav1.Object) (addrs []netip.Addr, cname string, ok bool) {
+	return targetFrom(obj, targetAnnotationKey, externalDnsTargetAnnotationKey)
+}
+
+// internalTarget returns the addresses requested by the internal target annotation, which are
+// served to the internal view instead of the regular ones
+func internalTarget(obj metav1.Object) (addrs []netip.Addr, cname string, ok bool) {
+	return targetFrom(obj, internalTargetAnnotationKey)
+}
+
+// targetFrom parses the first of the given target annotations that holds a valid target
+func targetFrom(obj metav1.Object, keys ...string) (addrs []netip.Addr, cname string, ok bool) {
+	for _, key := range keys {
+		value, exists := obj.GetAnnotations()[key]
+		if !exists {
+			continue
+		}
+
+		var hostnames []string
+		for _, target := range strings.Split(value, ",") {
+			target = strings.TrimSpace(target)
+			if addr, err := netip.ParseAddr(target); err == nil {
+				addrs = append(addrs, addr)
+				continue
+			}
+			target = strings.ToLower(strings.TrimSuffix(target, "."))
+			if err := checkHostname(target); err != nil {
+				log.Debugf("Ignoring invalid target %s of %s/%s: %s", target, obj.GetNamespace(), obj.GetName(), err)
+				continue
+			}
+			hostnames = append(hostnames, target)
+		}
+
+		if len(addrs) > 0 {
+			return addrs, "", true
+		}
+		if len(hostnames) > 0 {
+			return nil, dns.Fqdn(hostnames[0]), true
+		}
+	}
+	return nil, "", false
+}
+
+func lookupGatewayIndex(ctrl cache.SharedIndexInformer, resolver *hostResolver) lookupFunc {
+	return func(indexKeys []string) (result []lookupResult) {
+		var objs []interface{}
+		for _, key := range indexKeys {
+			obj, _ := ctrl.GetIndexer().ByIndex(gatewayHostnameIndex, strings.ToLower(key))
+			objs = append(objs, obj...)
+		}
+		log.Debugf("Found %d matching Gateway objects", len(objs))
+		for _, obj := range objs {
+			gateway, _ := obj.(*gatewayapi_v1.Gateway)
+			if !gatewayProgrammed(gateway) {
+				log.Debugf("Skipping Gateway %s/%s, which isn't programmed", gateway.Namespace, gateway.Name)
+				continue
+			}
+
+			found := lookupResult{kind: "Gateway", object: gateway}
+			found.addrs, found.cname = gatewayAddresses(gateway, resolver)
+			found.internal, found.internalCNAME, _ = internalTarget(gateway)
+			result = append(result, found)
+		}
+
+		return
+	}
+}
+
+func lookupIngressIndex(ctrl cache.SharedIndexInformer, resolver *hostResolver) lookupFunc {
+	return func(indexKeys []string) (result []lookupResult) {
 		var objs []interface{}
 		for _, key := range indexKeys {
 			obj, _ := ctrl.GetIndexer().ByIndex(ingressHostnameIndex, strings.ToLower(key))
@@ -585,17 +1444,25 @@ func lookupIngressIndex(ctrl cache.SharedIndexInformer) func([]string) []netip.A
 		log.Debugf("Found %d matching Ingress objects", len(objs))
 		for _, obj := range objs {
 			ingress, _ := obj.(*networking.Ingress)
+			found := lookupResult{kind: "Ingress", object: ingress}
+			found.internal, found.internalCNAME, _ = internalTarget(ingress)
 
-			result = append(result, fetchIngressLoadBalancerIPs(ingress.Status.LoadBalancer.Ingress)...)
+			if addrs, cname, ok := annotatedTarget(ingress); ok {
+				found.addrs, found.cname = addrs, cname
+			} else {
+				found.addrs = fetchIngressLoadBalancerIPs(ingress.Status.LoadBalancer.Ingress, resolver)
+			}
+			result = append(result, found)
 		}
 
 		return
 	}
 }
 
-func fetchGatewayIPs(gw *gatewayapi_v1.Gateway) (results []netip.Addr) {
+func fetchGatewayIPs(gw *gatewayapi_v1.Gateway, resolver *hostResolver) (results []netip.Addr) {
 	for _, addr := range gw.Status.Addresses {
-		if *addr.Type == gatewayapi_v1.IPAddressType {
+		// the address type defaults to IPAddress when omitted
+		if addr.Type == nil || *addr.Type == gatewayapi_v1.IPAddressType {
 			addr, err := netip.ParseAddr(addr.Value)
 			if err != nil {
 				continue
@@ -605,37 +1472,17 @@ func fetchGatewayIPs(gw *gatewayapi_v1.Gateway) (results []netip.Addr) {
 		}
 
 		if *addr.Type == gatewayapi_v1.HostnameAddressType {
-			ips, err := net.LookupIP(addr.Value)
-			if err != nil {
-				continue
-			}
-			for _, ip := range ips {
-				addr, err := netip.ParseAddr(ip.String())
-				if err != nil {
-					continue
-				}
-				results = append(results, addr)
-			}
+			results = append(results, resolver.lookup(addr.Value)...)
 		}
 	}
 	return
 }
 
-func fetchServiceLoadBalancerIPs(ingresses []core.LoadBalancerIngress) (results []netip.Addr) {
+func fetchServiceLoadBalancerIPs(ingresses []core.LoadBalancerIngress, resolver *hostResolver) (results []netip.Addr) {
 	for _, address := range ingresses {
 		if address.Hostname != "" {
 			log.Debugf("Looking up hostname %s", address.Hostname)
-			ips, err := net.LookupIP(address.Hostname)
-			if err != nil {
-				continue
-			}
-			for _, ip := range ips {
-				addr, err := netip.ParseAddr(ip.String())
-				if err != nil {
-					continue
-				}
-				results = append(results, addr)
-			}
+			results = append(results, resolver.lookup(address.Hostname)...)
 		} else if address.IP != "" {
 			addr, err := netip.ParseAddr(address.IP)
 			if err != nil {
@@ -647,21 +1494,11 @@ func fetchServiceLoadBalancerIPs(ingresses []core.LoadBalancerIngress) (results
 	return
 }
 
-func fetchIngressLoadBalancerIPs(ingresses []networking.IngressLoadBalancerIngress) (results []netip.Addr) {
+func fetchIngressLoadBalancerIPs(ingresses []networking.IngressLoadBalancerIngress, resolver *hostResolver) (results []netip.Addr) {
 	for _, address := range ingresses {
 		if address.Hostname != "" {
 			log.Debugf("Looking up hostname %s", address.Hostname)
-			ips, err := net.LookupIP(address.Hostname)
-			if err != nil {
-				continue
-			}
-			for _, ip := range ips {
-				addr, err := netip.ParseAddr(ip.String())
-				if err != nil {
-					continue
-				}
-				results = append(results, addr)
-			}
+			results = append(results, resolver.lookup(address.Hostname)...)
 		} else if address.IP != "" {
 			addr, err := netip.ParseAddr(address.IP)
 			if err != nil {
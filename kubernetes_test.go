@@ -2,6 +2,9 @@ package gateway
 
 import (
 	"context"
+	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/coredns/coredns/plugin/test"
@@ -14,6 +17,8 @@ import (
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	gatewayapi_v1 "sigs.k8s.io/gateway-api/apis/v1"
 	gatewayapi_v1alpha2 "sigs.k8s.io/gateway-api/apis/v1alpha2"
 	gatewayClient "sigs.k8s.io/gateway-api/pkg/client/clientset/versioned"
@@ -28,8 +33,8 @@ func TestController(t *testing.T) {
 		client:      client,
 		gwClient:    gwClient,
 		nginxClient: nginxClient,
-		hasSynced:   true,
 	}
+	ctrl.synced.Store(true)
 	addServices(client)
 	addIngresses(client)
 	addGateways(gwClient)
@@ -48,7 +53,7 @@ func TestController(t *testing.T) {
 		if !isFound(index, found) {
 			t.Errorf("Ingress key %s not found in index: %v", index, found)
 		}
-		ips := fetchIngressLoadBalancerIPs(testObj.Status.LoadBalancer.Ingress)
+		ips := fetchIngressLoadBalancerIPs(testObj.Status.LoadBalancer.Ingress, nil)
 		if len(ips) != 1 {
 			t.Errorf("Unexpected number of IPs found %d", len(ips))
 		}
@@ -59,7 +64,7 @@ func TestController(t *testing.T) {
 		if !isFound(index, found) {
 			t.Errorf("Service key %s not found in index: %v", index, found)
 		}
-		ips := fetchServiceLoadBalancerIPs(testObj.Status.LoadBalancer.Ingress)
+		ips := fetchServiceLoadBalancerIPs(testObj.Status.LoadBalancer.Ingress, nil)
 		if len(ips) != 1 {
 			t.Errorf("Unexpected number of IPs found %d", len(ips))
 		}
@@ -106,6 +111,360 @@ func TestController(t *testing.T) {
 			t.Errorf("Gateway key %s not found in index: %v", index, found)
 		}
 	}
+
+	for index, testObj := range testAnnotatedGateways {
+		found, _ := gatewayHostnameIndexFunc(testObj)
+		if !isFound(index, found) {
+			t.Errorf("Gateway hostname %s not found in index: %v", index, found)
+		}
+	}
+}
+
+func TestUnsynced(t *testing.T) {
+	ctrl := &KubeController{}
+	if unsynced := ctrl.Unsynced(); len(unsynced) != 0 {
+		t.Errorf("Expected no unsynced resources without informers, got %v", unsynced)
+	}
+
+	client := fake.NewSimpleClientset()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	services := newStrippedInformer(&cache.ListWatch{
+		ListFunc:  serviceLister(ctx, client, core.NamespaceAll),
+		WatchFunc: serviceWatcher(ctx, client, core.NamespaceAll),
+	}, &core.Service{}, defaultResyncPeriod, cache.Indexers{serviceHostnameIndex: serviceHostnameIndexFunc})
+	ingresses := newStrippedInformer(&cache.ListWatch{
+		ListFunc:  ingressLister(ctx, client, core.NamespaceAll),
+		WatchFunc: ingressWatcher(ctx, client, core.NamespaceAll),
+	}, &networking.Ingress{}, defaultResyncPeriod, cache.Indexers{ingressHostnameIndex: ingressHostnameIndexFunc})
+	ctrl.register("Service", services)
+	ctrl.register("Ingress", ingresses)
+
+	if unsynced := ctrl.Unsynced(); fmt.Sprint(unsynced) != "[Ingress Service]" {
+		t.Errorf("Expected all resources to be unsynced before running, got %v", unsynced)
+	}
+
+	go services.Run(ctx.Done())
+	stopCh := make(chan struct{})
+	close(stopCh)
+	if ctrl.waitForSync(stopCh) {
+		t.Errorf("Expected the wait to stop while Ingresses aren't synced")
+	}
+
+	go ingresses.Run(ctx.Done())
+	if !ctrl.waitForSync(ctx.Done()) {
+		t.Errorf("Expected the wait to succeed once all informers run")
+	}
+	if unsynced := ctrl.Unsynced(); len(unsynced) != 0 {
+		t.Errorf("Expected no unsynced resources, got %v", unsynced)
+	}
+
+	ctrl.unregister("Ingress")
+	ctrl.register("Ingress", newStrippedInformer(&cache.ListWatch{}, &networking.Ingress{}, defaultResyncPeriod, cache.Indexers{}))
+	if unsynced := ctrl.Unsynced(); fmt.Sprint(unsynced) != "[Ingress]" {
+		t.Errorf("Expected a replaced informer to be unsynced, got %v", unsynced)
+	}
+}
+
+func TestSharedController(t *testing.T) {
+	t.Cleanup(setupLookupFuncs)
+
+	// the clients are built from the kubeconfig, nothing has to listen on the server address
+	kubeconfig := filepath.Join(t.TempDir(), "kubeconfig")
+	if err := os.WriteFile(kubeconfig, []byte(`apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: http://127.0.0.1:1
+contexts:
+- name: test
+  context:
+    cluster: test
+current-context: test
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	newInstance := func(kubeContext string) *Gateway {
+		gw := newGateway()
+		gw.clusters = []clusterConfig{{file: kubeconfig, context: kubeContext}}
+		if err := gw.RunKubeController(ctx); err != nil {
+			t.Fatalf("Expected no error, got %s", err)
+		}
+		return gw
+	}
+
+	// a reloaded instance with the same configuration takes over the running controller
+	old := newInstance("test")
+	reloaded := newInstance("test")
+	if old.Controller != reloaded.Controller {
+		t.Errorf("Expected instances with the same configuration to share a controller")
+	}
+	other := newInstance("")
+	if other.Controller == old.Controller {
+		t.Errorf("Expected instances with another configuration to get their own controller")
+	}
+
+	_ = old.StopKubeController()
+	shared := sharedControllers.m[reloaded.controllerKey(reloaded.clusters[0])]
+	if shared == nil || shared.refs != 1 {
+		t.Fatalf("Expected the controller to keep running for the reloaded instance, got %+v", shared)
+	}
+	select {
+	case <-shared.done:
+		t.Errorf("Expected the controller not to be stopped while in use")
+	default:
+	}
+
+	_ = other.StopKubeController()
+	_ = reloaded.StopKubeController()
+	select {
+	case <-shared.done:
+	default:
+		t.Errorf("Expected the controller to be stopped once unused")
+	}
+	if len(sharedControllers.m) != 0 {
+		t.Errorf("Expected no running controllers, got %v", sharedControllers.m)
+	}
+	if found := lookupResource("Ingress").listKeys(); found != nil {
+		t.Errorf("Expected the Ingress lookup to be cleared, got %v", found)
+	}
+
+	// stopping twice is harmless
+	if err := reloaded.StopKubeController(); err != nil {
+		t.Errorf("Expected no error, got %s", err)
+	}
+}
+
+func TestAnnotatedHostnames(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	ctrl := &KubeController{recorder: recorder}
+	handler := ctrl.hostnameValidationHandler()
+
+	tests := []struct {
+		annotations map[string]string
+		expected    []string
+		invalid     int
+	}{
+		{map[string]string{}, nil, 0},
+		{map[string]string{"coredns.io/hostname": "a.example.org"}, []string{"a.example.org"}, 0},
+		{map[string]string{"coredns.io/hostname": "a.example.org, B.example.org.,"}, []string{"a.example.org", "b.example.org"}, 0},
+		{map[string]string{"external-dns.alpha.kubernetes.io/hostname": "a.example.org,c_d.example.org"}, []string{"a.example.org"}, 1},
+		{map[string]string{"coredns.io/hostname": "-bad", "external-dns.alpha.kubernetes.io/hostname": "a.example.org"}, []string{"a.example.org"}, 1},
+		{map[string]string{"coredns.io/hostname": "a.example.org", "external-dns.alpha.kubernetes.io/hostname": "b.example.org"}, []string{"a.example.org"}, 0},
+	}
+
+	for i, test := range tests {
+		ingress := &networking.Ingress{ObjectMeta: meta.ObjectMeta{Name: "ing", Namespace: "ns1", Annotations: test.annotations}}
+		hostnames, invalid := annotatedHostnames(ingress)
+		if len(invalid) != test.invalid {
+			t.Errorf("Test %d: expected %d invalid hostnames, got %v", i, test.invalid, invalid)
+		}
+		if len(hostnames) != len(test.expected) {
+			t.Errorf("Test %d: expected hostnames %v, got %v", i, test.expected, hostnames)
+			continue
+		}
+		for _, hostname := range test.expected {
+			if !isFound(hostname, hostnames) {
+				t.Errorf("Test %d: expected hostname %s in %v", i, hostname, hostnames)
+			}
+		}
+
+		events := len(recorder.Events)
+		handler.OnAdd(ingress, false)
+		if len(recorder.Events)-events != test.invalid {
+			t.Errorf("Test %d: expected %d events, got %d", i, test.invalid, len(recorder.Events)-events)
+		}
+	}
+}
+
+func TestAnnotatedTarget(t *testing.T) {
+	tests := []struct {
+		annotations map[string]string
+		addrs       []string
+		cname       string
+		found       bool
+	}{
+		{map[string]string{}, nil, "", false},
+		{map[string]string{"coredns.io/target": "203.0.113.1"}, []string{"203.0.113.1"}, "", true},
+		{map[string]string{"coredns.io/target": "203.0.113.1, 2001:db8::1"}, []string{"203.0.113.1", "2001:db8::1"}, "", true},
+		{map[string]string{"external-dns.alpha.kubernetes.io/target": "Edge.CDN.example.net."}, nil, "edge.cdn.example.net.", true},
+		{map[string]string{"coredns.io/target": "edge.example.net,203.0.113.1"}, []string{"203.0.113.1"}, "", true},
+		{map[string]string{"coredns.io/target": "not_valid", "external-dns.alpha.kubernetes.io/target": "203.0.113.2"}, []string{"203.0.113.2"}, "", true},
+		{map[string]string{"coredns.io/target": "not_valid"}, nil, "", false},
+	}
+
+	for i, test := range tests {
+		svc := &core.Service{ObjectMeta: meta.ObjectMeta{Name: "svc", Namespace: "ns1", Annotations: test.annotations}}
+		addrs, cname, found := annotatedTarget(svc)
+		if found != test.found || cname != test.cname || len(addrs) != len(test.addrs) {
+			t.Errorf("Test %d: expected %v/%q (%t), got %v/%q (%t)", i, test.addrs, test.cname, test.found, addrs, cname, found)
+			continue
+		}
+		for j, addr := range addrs {
+			if addr.String() != test.addrs[j] {
+				t.Errorf("Test %d: expected addresses %v, got %v", i, test.addrs, addrs)
+			}
+		}
+	}
+}
+
+func TestGatewayStatus(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	programmed := func(status meta.ConditionStatus, listeners ...gatewayapi_v1.ListenerStatus) *gatewayapi_v1.Gateway {
+		return &gatewayapi_v1.Gateway{
+			ObjectMeta: meta.ObjectMeta{Name: "gw-" + string(status), Namespace: "ns1"},
+			Status: gatewayapi_v1.GatewayStatus{
+				Addresses:  []gatewayapi_v1.GatewayStatusAddress{{Value: "192.0.2.1"}},
+				Conditions: []meta.Condition{{Type: string(gatewayapi_v1.GatewayConditionProgrammed), Status: status}},
+				Listeners:  listeners,
+			},
+		}
+	}
+	listener := func(name string, resolved meta.ConditionStatus) gatewayapi_v1.ListenerStatus {
+		return gatewayapi_v1.ListenerStatus{
+			Name:       gatewayapi_v1.SectionName(name),
+			Conditions: []meta.Condition{{Type: string(gatewayapi_v1.ListenerConditionResolvedRefs), Status: resolved}},
+		}
+	}
+
+	gwClient := gwFake.NewSimpleClientset()
+	for _, gw := range []*gatewayapi_v1.Gateway{
+		programmed(meta.ConditionTrue, listener("https", meta.ConditionTrue), listener("broken", meta.ConditionFalse)),
+		programmed(meta.ConditionFalse),
+	} {
+		if _, err := gwClient.GatewayV1().Gateways(gw.Namespace).Create(ctx, gw, meta.CreateOptions{}); err != nil {
+			t.Fatalf("Failed to create Gateway: %s", err)
+		}
+	}
+	informer := newStrippedInformer(
+		&cache.ListWatch{
+			ListFunc:  gatewayLister(ctx, gwClient, core.NamespaceAll),
+			WatchFunc: gatewayWatcher(ctx, gwClient, core.NamespaceAll),
+		},
+		&gatewayapi_v1.Gateway{},
+		0,
+		cache.Indexers{gatewayUniqueIndex: gatewayIndexFunc},
+	)
+	go informer.Run(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		t.Fatal("Failed to sync Gateways")
+	}
+
+	section := func(name string) *gatewayapi_v1.SectionName {
+		s := gatewayapi_v1.SectionName(name)
+		return &s
+	}
+	tests := []struct {
+		gateway string
+		section *gatewayapi_v1.SectionName
+		found   bool
+	}{
+		{"gw-True", nil, true},
+		{"gw-True", section("https"), true},
+		{"gw-True", section("broken"), false},
+		{"gw-False", nil, false},
+	}
+	for i, tc := range tests {
+		refs := []gatewayapi_v1.ParentReference{{Name: gatewayapi_v1.ObjectName(tc.gateway), SectionName: tc.section}}
+		if found := lookupGateways(informer, refs, "ns1", nil); (len(found.addrs) > 0) != tc.found {
+			t.Errorf("Test %d: expected addresses %t, got %v", i, tc.found, found.addrs)
+		}
+	}
+
+	for state, found := range map[string]bool{"Valid": true, "Warning": false, "Invalid": false, "": false} {
+		vs := &nginx.VirtualServer{
+			ObjectMeta: meta.ObjectMeta{Name: "vs", Namespace: "ns1"},
+			Spec:       nginx.VirtualServerSpec{Host: "vs.example.org"},
+			Status:     nginx.VirtualServerStatus{State: state, ExternalEndpoints: []nginx.ExternalEndpoint{{IP: "192.0.2.2"}}},
+		}
+		indexer := cache.NewSharedIndexInformer(&cache.ListWatch{}, &nginx.VirtualServer{}, 0, cache.Indexers{virtualServerHostnameIndex: virtualServerHostnameIndexFunc})
+		indexer.GetIndexer().Add(vs)
+		if results := lookupVirtualServerIndex(indexer)([]string{"vs.example.org"}); (len(results) > 0) != found {
+			t.Errorf("Expected VirtualServer in state %q to be found: %t, got %v", state, found, results)
+		}
+	}
+}
+
+func TestGatewayChangeHandler(t *testing.T) {
+	routes := cache.NewSharedIndexInformer(&cache.ListWatch{}, &gatewayapi_v1.HTTPRoute{}, 0, cache.Indexers{routeParentIndex: routeParentIndexFunc})
+	for name, parent := range map[string]string{"attached": "gw-1", "other": "gw-2"} {
+		routes.GetIndexer().Add(&gatewayapi_v1.HTTPRoute{
+			ObjectMeta: meta.ObjectMeta{Name: name, Namespace: "ns1"},
+			Spec: gatewayapi_v1.HTTPRouteSpec{
+				CommonRouteSpec: gatewayapi_v1.CommonRouteSpec{ParentRefs: []gatewayapi_v1.ParentReference{{Name: gatewayapi_v1.ObjectName(parent)}}},
+				Hostnames:       []gatewayapi_v1.Hostname{gatewayapi_v1.Hostname(name + ".example.org")},
+			},
+		})
+	}
+
+	var notified [][]string
+	ctrl := &KubeController{onChange: func(keys []string) { notified = append(notified, keys) }}
+	handler := ctrl.gatewayChangeHandler([]attachedRoutes{{routes, httpRouteHostnameIndexFunc}})
+
+	gateway := &gatewayapi_v1.Gateway{
+		ObjectMeta: meta.ObjectMeta{Name: "gw-1", Namespace: "ns1", Annotations: map[string]string{hostnameAnnotationKey: "gw.example.org"}},
+		Status: gatewayapi_v1.GatewayStatus{
+			Addresses:  []gatewayapi_v1.GatewayStatusAddress{{Value: "192.0.2.1"}},
+			Conditions: []meta.Condition{{Type: string(gatewayapi_v1.GatewayConditionProgrammed), Status: meta.ConditionTrue}},
+		},
+	}
+	handler.OnAdd(gateway, false)
+	if len(notified) != 1 || len(notified[0]) != 2 || !isFound("gw.example.org", notified[0]) || !isFound("attached.example.org", notified[0]) {
+		t.Fatalf("Expected the names of the Gateway and its routes, got %v", notified)
+	}
+
+	// heartbeats and the published annotation don't change any answer
+	heartbeat := gateway.DeepCopy()
+	heartbeat.Annotations[publishedAnnotationKey] = "gw.example.org."
+	heartbeat.Status.Conditions[0].LastTransitionTime = meta.Now()
+	heartbeat.Status.Conditions[0].ObservedGeneration = 2
+	handler.OnUpdate(gateway, heartbeat)
+	if len(notified) != 1 {
+		t.Errorf("Expected metadata-only updates to be ignored, got %v", notified[1:])
+	}
+
+	moved := heartbeat.DeepCopy()
+	moved.Status.Addresses[0].Value = "192.0.2.2"
+	handler.OnUpdate(heartbeat, moved)
+	if len(notified) != 2 || isFound("other.example.org", notified[1]) || !isFound("attached.example.org", notified[1]) {
+		t.Errorf("Expected the names of the Gateway and its routes once its addresses change, got %v", notified)
+	}
+
+	unprogrammed := moved.DeepCopy()
+	unprogrammed.Status.Conditions[0].Status = meta.ConditionFalse
+	handler.OnUpdate(moved, unprogrammed)
+	if len(notified) != 3 {
+		t.Errorf("Expected a notification once the Gateway isn't programmed anymore")
+	}
+}
+
+func TestObjectTTL(t *testing.T) {
+	tests := []struct {
+		annotations map[string]string
+		expected    uint32
+		found       bool
+	}{
+		{map[string]string{}, 0, false},
+		{map[string]string{"coredns.io/ttl": "30"}, 30, true},
+		{map[string]string{"external-dns.alpha.kubernetes.io/ttl": "2m"}, 120, true},
+		{map[string]string{"coredns.io/ttl": "10", "external-dns.alpha.kubernetes.io/ttl": "20"}, 10, true},
+		{map[string]string{"coredns.io/ttl": "-5"}, 0, false},
+		{map[string]string{"coredns.io/ttl": "soon", "external-dns.alpha.kubernetes.io/ttl": "15"}, 15, true},
+	}
+
+	for i, test := range tests {
+		svc := &core.Service{ObjectMeta: meta.ObjectMeta{Name: "svc", Namespace: "ns1", Annotations: test.annotations}}
+		ttl, found := objectTTL(svc)
+		if found != test.found || ttl != test.expected {
+			t.Errorf("Test %d: expected ttl %d (%t), got %d (%t)", i, test.expected, test.found, ttl, found)
+		}
+	}
 }
 
 func isFound(s string, ss []string) bool {
@@ -208,6 +567,22 @@ var testIngresses = map[string]*networking.Ingress{
 			},
 		},
 	},
+	"annotated.example.org": {
+		ObjectMeta: meta.ObjectMeta{
+			Name:      "ing3",
+			Namespace: "ns1",
+			Annotations: map[string]string{
+				"coredns.io/hostname": "annotated.example.org",
+			},
+		},
+		Status: networking.IngressStatus{
+			LoadBalancer: networking.IngressLoadBalancerStatus{
+				Ingress: []networking.IngressLoadBalancerIngress{
+					{IP: "192.0.0.3"},
+				},
+			},
+		},
+	},
 	"example.org": {
 		Spec: networking.IngressSpec{
 			Rules: []networking.IngressRule{
@@ -278,6 +653,25 @@ var testServices = map[string]*core.Service{
 			},
 		},
 	},
+	"second.example.org": {
+		ObjectMeta: meta.ObjectMeta{
+			Name:      "svc4",
+			Namespace: "ns1",
+			Annotations: map[string]string{
+				"external-dns.alpha.kubernetes.io/hostname": "first.example.org,second.example.org",
+			},
+		},
+		Spec: core.ServiceSpec{
+			Type: core.ServiceTypeLoadBalancer,
+		},
+		Status: core.ServiceStatus{
+			LoadBalancer: core.LoadBalancerStatus{
+				Ingress: []core.LoadBalancerIngress{
+					{IP: "192.0.0.3"},
+				},
+			},
+		},
+	},
 }
 
 var testVirtualServers = map[string]*nginx.VirtualServer{
@@ -290,6 +684,7 @@ var testVirtualServers = map[string]*nginx.VirtualServer{
 			Host: "vs1.example.org",
 		},
 		Status: nginx.VirtualServerStatus{
+			State: "Valid",
 			ExternalEndpoints: []nginx.ExternalEndpoint{
 				{IP: "192.0.0.1"},
 			},
@@ -320,6 +715,18 @@ var testGateways = map[string]*gatewayapi_v1.Gateway{
 	},
 }
 
+var testAnnotatedGateways = map[string]*gatewayapi_v1.Gateway{
+	"gw.example.org": {
+		ObjectMeta: meta.ObjectMeta{
+			Name:      "gw-3",
+			Namespace: "ns1",
+			Annotations: map[string]string{
+				"coredns.io/hostname": "gw.example.org,www.gw.example.org",
+			},
+		},
+	},
+}
+
 var testHTTPRoutes = map[string]*gatewayapi_v1.HTTPRoute{
 	"route-1.gw-1.example.com": {
 		ObjectMeta: meta.ObjectMeta{
@@ -0,0 +1,110 @@
+package gateway
+
+import (
+	"context"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/metrics"
+	"github.com/miekg/dns"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// requestCount is the number of queries answered by the plugin, by the kind of the objects in the answer.
+	requestCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: thisPlugin,
+		Name:      "requests_total",
+		Help:      "Counter of queries answered by the plugin, by zone, type, rcode and the resource kind in the answer.",
+	}, []string{"server", "zone", "type", "rcode", "resource"})
+	// lookupDuration is the time spent finding the answer to a query.
+	lookupDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: thisPlugin,
+		Name:      "lookup_duration_seconds",
+		Buckets:   plugin.SlimTimeBuckets,
+		Help:      "Histogram of the time (in seconds) each lookup of the answer to a query took.",
+	}, []string{"server", "zone"})
+	// indexedHostnames is the number of hostnames published by each resource kind in each zone.
+	indexedHostnames = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: thisPlugin,
+		Name:      "indexed_hostnames",
+		Help:      "Gauge of the hostnames indexed for each resource kind, by zone, updated when the snapshot is fully rebuilt.",
+	}, []string{"zone", "resource"})
+	// resourceSynced reports whether the informer of each watched resource has synced.
+	resourceSynced = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: thisPlugin,
+		Name:      "resource_synced",
+		Help:      "Gauge set to 1 for watched resources whose informer has synced, 0 while it's syncing.",
+	}, []string{"resource", "cluster"})
+	// resolverRequests is the number of load balancer hostnames looked up in the resolver cache.
+	resolverRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: thisPlugin,
+		Name:      "resolver_cache_requests_total",
+		Help:      "Counter of load balancer hostname lookups, by whether they were answered from the cache.",
+	}, []string{"result"})
+	// resolverFailures is the number of failed resolutions of load balancer hostnames.
+	resolverFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: thisPlugin,
+		Name:      "resolver_failures_total",
+		Help:      "Counter of failed resolutions of load balancer hostnames.",
+	})
+	// hostnameConflicts is the number of hostnames of each zone currently claimed by more than one object.
+	hostnameConflicts = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: thisPlugin,
+		Name:      "hostname_conflicts",
+		Help:      "Gauge of the hostnames currently claimed by more than one Kubernetes object, by zone.",
+	}, []string{"zone"})
+	// filteredAddresses is the number of addresses dropped by the allow_cidr and deny_cidr options.
+	filteredAddresses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: thisPlugin,
+		Name:      "filtered_addresses_total",
+		Help:      "Counter of object addresses dropped by the CIDR filters, counted once when an address starts being filtered out of a name, by zone and resource kind.",
+	}, []string{"zone", "resource"})
+	// resourceFailing reports the resources that can't be synced, while the others keep being served.
+	resourceFailing = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: thisPlugin,
+		Name:      "resource_failing",
+		Help:      "Gauge set to 1 for resources that can't be synced from the API server.",
+	}, []string{"resource", "cluster"})
+)
+
+// observeQuery counts a query answered by the plugin. The resource label is the kind of the
+// objects ranked first in the answer, or "none" if no object matched.
+func observeQuery(ctx context.Context, zone string, qtype uint16, rcode int, ans *answer) {
+	kind := "none"
+	if ans != nil && len(ans.winners) > 0 {
+		kind = ans.winners[0].kind
+	}
+	requestCount.WithLabelValues(metrics.WithServer(ctx), zone, qtypeLabel(qtype), dns.RcodeToString[rcode], kind).Inc()
+}
+
+// observeLookup records the time spent finding the answer to a query
+func observeLookup(ctx context.Context, zone string, start time.Time) {
+	lookupDuration.WithLabelValues(metrics.WithServer(ctx), zone).Observe(time.Since(start).Seconds())
+}
+
+// qtypeLabel bounds the values of the type label to the known types
+func qtypeLabel(qtype uint16) string {
+	if name, ok := dns.TypeToString[qtype]; ok {
+		return name
+	}
+	return "other"
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
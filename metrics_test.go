@@ -0,0 +1,50 @@
+package gateway
+
+import (
+	"context"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	gw := newGateway()
+	gw.Zones = []string{"example.com."}
+	gw.Next = test.NextHandler(dns.RcodeSuccess, nil)
+	gw.Controller = newSyncedController()
+	setupLookupFuncs()
+
+	ctx := context.TODO()
+	query := func(name string) {
+		r := new(dns.Msg)
+		r.SetQuestion(name, dns.TypeA)
+		gw.ServeDNS(ctx, dnstest.NewRecorder(&test.ResponseWriter{}), r)
+	}
+
+	// queries of the test server have no server label
+	found := requestCount.WithLabelValues("", "example.com.", "A", "NOERROR", "Ingress")
+	missing := requestCount.WithLabelValues("", "example.com.", "A", "NXDOMAIN", "none")
+	foundBefore, missingBefore := testutil.ToFloat64(found), testutil.ToFloat64(missing)
+
+	query("domain.example.com.")
+	query("unknown.example.com.")
+	query("unknown.example.com.")
+
+	if value := testutil.ToFloat64(found) - foundBefore; value != 1 {
+		t.Errorf("Expected 1 query answered with an Ingress, got %v", value)
+	}
+	if value := testutil.ToFloat64(missing) - missingBefore; value != 2 {
+		t.Errorf("Expected 2 queries without an answer, got %v", value)
+	}
+	if series := testutil.CollectAndCount(lookupDuration); series == 0 {
+		t.Errorf("Expected lookup durations to be recorded")
+	}
+
+	gw.buildSnapshot()
+	if value := testutil.ToFloat64(indexedHostnames.WithLabelValues("example.com.", "Ingress")); value != float64(len(testIngressIndexes)) {
+		t.Errorf("Expected %d indexed Ingress hostnames, got %v", len(testIngressIndexes), value)
+	}
+}
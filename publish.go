@@ -0,0 +1,327 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	// publishedAnnotationKey lists the FQDNs an object is published as, written by the publisher
+	publishedAnnotationKey = "coredns.io/published-fqdns"
+	publishedReason        = "Published"
+	notPublishedReason     = "NotPublished"
+
+	publishLeaseName = "k8s-gateway-publisher"
+	// publishInterval is how often objects are reconciled without any change to the answers
+	publishInterval = time.Minute
+	// namespaceFile holds the namespace of the pod the plugin runs in
+	namespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+)
+
+// publication is what became of the hostnames of a single object
+type publication struct {
+	result  lookupResult
+	fqdns   []string
+	skipped []string
+}
+
+func (p *publication) publish(fqdn string) {
+	if !slices.Contains(p.fqdns, fqdn) {
+		p.fqdns = append(p.fqdns, fqdn)
+	}
+}
+
+func (p *publication) skip(format string, args ...interface{}) {
+	if reason := fmt.Sprintf(format, args...); !slices.Contains(p.skipped, reason) {
+		p.skipped = append(p.skipped, reason)
+	}
+}
+
+// objectKey identifies an object across resource kinds and clusters
+func objectKey(r lookupResult) string {
+	return strings.Join([]string{r.cluster, r.kind, r.namespace(), r.object.GetName()}, "/")
+}
+
+// publications returns what became of the hostnames of every object, according to the current
+// snapshot. It returns nil until the snapshot is built.
+func (gw *Gateway) publications() map[string]*publication {
+	snap := gw.snapshot.Load()
+	if snap == nil {
+		return nil
+	}
+
+	pubs := make(map[string]*publication)
+	for _, resource := range orderedResources {
+		for _, key := range resource.listKeys() {
+			fqdns := gw.servingCandidates(resource, strings.ToLower(key))
+
+			for _, result := range gw.servedResults(resource.find([]string{key})) {
+				if result.object == nil {
+					continue
+				}
+				id := objectKey(result)
+				p, ok := pubs[id]
+				if !ok {
+					p = &publication{result: result}
+					pubs[id] = p
+					for _, err := range invalidHostnames(result.object) {
+						p.skip("invalid hostname annotation: %s", err)
+					}
+				}
+
+				if len(fqdns) == 0 {
+					p.skip("%s is outside of the zones serving %s resources", key, result.kind)
+					continue
+				}
+				for _, fqdn := range fqdns {
+					ans := snap.answers[fqdn]
+					switch {
+					case ans != nil && slices.ContainsFunc(ans.winners, func(w lookupResult) bool { return sameObject(w, result) }):
+						p.publish(stripClosingDot(fqdn))
+					case !result.claims():
+						p.skip("%s has no addresses", stripClosingDot(fqdn))
+					case !gw.healthy(result):
+						p.skip("%s has no ready endpoints", stripClosingDot(fqdn))
+					case ans != nil:
+						p.skip("%s is claimed by %s", stripClosingDot(fqdn), describeResults(ans.winners))
+					default:
+						// e.g. all of its addresses were filtered, or the cluster policy picked another cluster
+						p.skip("%s is not answered, its addresses are filtered or not selected", stripClosingDot(fqdn))
+					}
+				}
+			}
+		}
+	}
+
+	for _, p := range pubs {
+		sort.Strings(p.fqdns)
+		sort.Strings(p.skipped)
+	}
+	return pubs
+}
+
+// servingCandidates returns the candidates of an index key in the zones serving the resource
+func (gw *Gateway) servingCandidates(resource *resourceWithIndex, key string) (fqdns []string) {
+	zones := plugin.Zones(gw.Zones)
+	for _, fqdn := range gw.candidates(key) {
+		if zc := gw.configFor(zones.Matches(fqdn)); slices.Contains(zc.Resources, resource) {
+			fqdns = append(fqdns, fqdn)
+		}
+	}
+	return fqdns
+}
+
+func sameObject(a, b lookupResult) bool {
+	return a.object != nil && b.object != nil && objectKey(a) == objectKey(b)
+}
+
+func invalidHostnames(object kubeObject) []error {
+	_, invalid := annotatedHostnames(object)
+	return invalid
+}
+
+// publisher writes the FQDNs of every object to its annotations and reports the hostnames that
+// are skipped with Events. It only runs in the replica holding the lease.
+type publisher struct {
+	gw *Gateway
+	// published and skipped remember what was last recorded, so that objects are only patched and
+	// Events only emitted on changes
+	published map[string]publishedObject
+	skipped   map[string]string
+}
+
+type publishedObject struct {
+	result lookupResult
+	fqdns  string
+}
+
+// runPublisher takes part in the election of the replica writing back published FQDNs, until ctx is done
+func (gw *Gateway) runPublisher(ctx context.Context) {
+	ctrl := gw.Controller
+	identity, err := os.Hostname()
+	if err != nil {
+		identity = thisPlugin
+	}
+	identity += "_" + rand.String(8)
+
+	namespace := gw.publishNamespace
+	if namespace == "" {
+		namespace = podNamespace()
+	}
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Name: publishLeaseName, Namespace: namespace},
+		Client:     ctrl.client.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+	}
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   15 * time.Second,
+		RenewDeadline:   10 * time.Second,
+		RetryPeriod:     2 * time.Second,
+		ReleaseOnCancel: true,
+		Name:            publishLeaseName,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				log.Infof("Publishing FQDNs to Kubernetes objects as %s", identity)
+				p := &publisher{gw: gw, published: make(map[string]publishedObject), skipped: make(map[string]string)}
+				p.run(ctx)
+			},
+			OnStoppedLeading: func() {
+				log.Infof("Stopped publishing FQDNs to Kubernetes objects")
+			},
+		},
+	})
+	if err != nil {
+		log.Errorf("Failed to set up the election of the publisher: %s", err)
+		return
+	}
+
+	// the election is run again after losing the lease, until the plugin is stopped
+	for ctx.Err() == nil {
+		elector.Run(ctx)
+	}
+}
+
+func podNamespace() string {
+	if ns, err := os.ReadFile(namespaceFile); err == nil && len(ns) > 0 {
+		return strings.TrimSpace(string(ns))
+	}
+	return core.NamespaceDefault
+}
+
+func (p *publisher) run(ctx context.Context) {
+	ticker := time.NewTicker(publishInterval)
+	defer ticker.Stop()
+
+	for {
+		p.reconcile(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-p.gw.snapshotChanged:
+		}
+	}
+}
+
+// reconcile records the current publications on the objects
+func (p *publisher) reconcile(ctx context.Context) {
+	pubs := p.gw.publications()
+	if pubs == nil {
+		return
+	}
+
+	for id, pub := range pubs {
+		p.record(ctx, id, pub)
+	}
+
+	// objects that no longer have any hostname aren't published anymore
+	for id, published := range p.published {
+		if _, ok := pubs[id]; !ok {
+			p.record(ctx, id, &publication{result: published.result})
+		}
+	}
+}
+
+func (p *publisher) record(ctx context.Context, id string, pub *publication) {
+	object := pub.result.object
+	ctrl := p.gw.controllerFor(pub.result.cluster)
+	if ctrl == nil {
+		return
+	}
+
+	// the cached object may not reflect the last patch yet
+	fqdns := strings.Join(pub.fqdns, ",")
+	current := object.GetAnnotations()[publishedAnnotationKey]
+	if last, ok := p.published[id]; ok {
+		current = last.fqdns
+	}
+	if current != fqdns {
+		err := ctrl.annotate(ctx, pub.result.kind, object, publishedAnnotationKey, fqdns)
+		switch {
+		case apierrors.IsNotFound(err):
+			delete(p.published, id)
+			delete(p.skipped, id)
+			return
+		case err != nil:
+			log.Warningf("Failed to record the published FQDNs of %s: %s", pub.result, err)
+			return
+		}
+		if ctrl.recorder != nil {
+			if fqdns != "" {
+				ctrl.recorder.Eventf(object, core.EventTypeNormal, publishedReason, "Published as %s", fqdns)
+			} else {
+				ctrl.recorder.Eventf(object, core.EventTypeNormal, publishedReason, "No longer published")
+			}
+		}
+	}
+	if fqdns != "" {
+		p.published[id] = publishedObject{result: pub.result, fqdns: fqdns}
+	} else {
+		delete(p.published, id)
+	}
+
+	skipped := strings.Join(pub.skipped, "; ")
+	if p.skipped[id] != skipped {
+		if skipped != "" && ctrl.recorder != nil {
+			ctrl.recorder.Eventf(object, core.EventTypeWarning, notPublishedReason, "Hostnames not published: %s", skipped)
+		}
+		p.skipped[id] = skipped
+	}
+	if skipped == "" {
+		delete(p.skipped, id)
+	}
+}
+
+// annotate sets an annotation of an object, an empty value removes it
+func (ctrl *KubeController) annotate(ctx context.Context, kind string, object kubeObject, key, value string) error {
+	var annotation interface{}
+	if value != "" {
+		annotation = value
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": map[string]interface{}{key: annotation}},
+	})
+	if err != nil {
+		return err
+	}
+
+	ns, name := object.GetNamespace(), object.GetName()
+	opts := metav1.PatchOptions{FieldManager: thisPlugin}
+	switch kind {
+	case "Service":
+		_, err = ctrl.client.CoreV1().Services(ns).Patch(ctx, name, types.MergePatchType, patch, opts)
+	case "Ingress":
+		_, err = ctrl.client.NetworkingV1().Ingresses(ns).Patch(ctx, name, types.MergePatchType, patch, opts)
+	case "Gateway":
+		_, err = ctrl.gwClient.GatewayV1().Gateways(ns).Patch(ctx, name, types.MergePatchType, patch, opts)
+	case "HTTPRoute":
+		_, err = ctrl.gwClient.GatewayV1().HTTPRoutes(ns).Patch(ctx, name, types.MergePatchType, patch, opts)
+	case "TLSRoute":
+		_, err = ctrl.gwClient.GatewayV1alpha2().TLSRoutes(ns).Patch(ctx, name, types.MergePatchType, patch, opts)
+	case "GRPCRoute":
+		_, err = ctrl.gwClient.GatewayV1alpha2().GRPCRoutes(ns).Patch(ctx, name, types.MergePatchType, patch, opts)
+	case "VirtualServer":
+		_, err = ctrl.nginxClient.K8sV1().VirtualServers(ns).Patch(ctx, name, types.MergePatchType, patch, opts)
+	default:
+		err = fmt.Errorf("unsupported kind %s", kind)
+	}
+	return err
+}
@@ -0,0 +1,147 @@
+package gateway
+
+import (
+	"context"
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	networking "k8s.io/api/networking/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestPublications(t *testing.T) {
+	gw, ctrl, recorder := setupPublishTest()
+
+	p := &publisher{gw: gw, published: make(map[string]publishedObject), skipped: make(map[string]string)}
+	p.reconcile(context.TODO())
+
+	expected := map[string]string{
+		"old":   "web.example.com,web.example.com.example.com",
+		"young": "",
+		"empty": "",
+	}
+	for name, fqdns := range expected {
+		ing, err := ctrl.client.NetworkingV1().Ingresses("default").Get(context.TODO(), name, meta.GetOptions{})
+		if err != nil {
+			t.Fatalf("Failed to get Ingress %s: %s", name, err)
+		}
+		if got := ing.Annotations[publishedAnnotationKey]; got != fqdns {
+			t.Errorf("Expected Ingress %s to be published as %q, got %q", name, fqdns, got)
+		}
+	}
+
+	events := drainEvents(recorder)
+	for _, event := range []string{
+		"Normal Published Published as web.example.com,web.example.com.example.com",
+		"Warning NotPublished Hostnames not published: web.example.com is claimed by Ingress default/old",
+		"Warning NotPublished Hostnames not published: empty.example.com has no addresses",
+	} {
+		if !containsPrefix(events, event) {
+			t.Errorf("Expected event %q, got %v", event, events)
+		}
+	}
+
+	// nothing changed, so nothing is recorded again
+	p.reconcile(context.TODO())
+	if events := drainEvents(recorder); len(events) != 0 {
+		t.Errorf("Expected no new events, got %v", events)
+	}
+
+	// Ingresses are no longer served in the zone
+	gw.zoneConfig.Resources = []*resourceWithIndex{lookupResource("Service")}
+	gw.buildSnapshot()
+	p.reconcile(context.TODO())
+
+	ing, _ := ctrl.client.NetworkingV1().Ingresses("default").Get(context.TODO(), "old", meta.GetOptions{})
+	if got, ok := ing.Annotations[publishedAnnotationKey]; ok {
+		t.Errorf("Expected the published FQDNs to be removed, got %q", got)
+	}
+	events = drainEvents(recorder)
+	if !containsPrefix(events, "Warning NotPublished Hostnames not published: web.example.com is outside of the zones serving Ingress resources") {
+		t.Errorf("Expected an event for the hostname outside of the zones, got %v", events)
+	}
+}
+
+func TestPublicationsFiltered(t *testing.T) {
+	gw, _, _ := setupPublishTest()
+	gw.denyCIDRs = []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}
+	gw.buildSnapshot()
+
+	for _, name := range []string{"old", "young"} {
+		p := gw.publications()["/Ingress/default/"+name]
+		if p == nil {
+			t.Fatalf("Expected a publication for Ingress %s", name)
+		}
+		if len(p.fqdns) != 0 || !slices.Contains(p.skipped, "web.example.com is not answered, its addresses are filtered or not selected") {
+			t.Errorf("Expected Ingress %s to be skipped for its filtered addresses, got %v and %v", name, p.fqdns, p.skipped)
+		}
+	}
+}
+
+func setupPublishTest() (*Gateway, *KubeController, *record.FakeRecorder) {
+	old := testPublishedIngress("old", "web.example.com", time.Hour)
+	young := testPublishedIngress("young", "web.example.com", time.Minute)
+	empty := testPublishedIngress("empty", "empty.example.com", time.Hour)
+
+	ctrl := newSyncedController()
+	ctrl.client = fake.NewSimpleClientset(old, young, empty)
+	recorder := record.NewFakeRecorder(10)
+	ctrl.recorder = recorder
+
+	gw := newGateway()
+	gw.Zones = []string{"example.com."}
+	gw.Controller = ctrl
+	gw.conflict = conflictPolicy{mode: conflictOldest}
+
+	addrs := map[string][]netip.Addr{"old": {netip.MustParseAddr("192.0.2.1")}, "young": {netip.MustParseAddr("192.0.2.2")}}
+	clearLookupFuncs()
+	lookupResource("Ingress").setLookup("", ctrl, func(keys []string) (results []lookupResult) {
+		for _, ing := range []*networking.Ingress{old, young, empty} {
+			for _, key := range keys {
+				if strings.EqualFold(ing.Spec.Rules[0].Host, key) {
+					results = append(results, lookupResult{kind: "Ingress", object: ing, addrs: addrs[ing.Name]})
+				}
+			}
+		}
+		return results
+	}, func() []string { return []string{"web.example.com", "empty.example.com"} })
+	gw.buildSnapshot()
+
+	return gw, ctrl, recorder
+}
+
+func testPublishedIngress(name, host string, age time.Duration) *networking.Ingress {
+	return &networking.Ingress{
+		ObjectMeta: meta.ObjectMeta{
+			Name:              name,
+			Namespace:         "default",
+			CreationTimestamp: meta.NewTime(time.Now().Add(-age)),
+		},
+		Spec: networking.IngressSpec{Rules: []networking.IngressRule{{Host: host}}},
+	}
+}
+
+func drainEvents(recorder *record.FakeRecorder) (events []string) {
+	for {
+		select {
+		case event := <-recorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func containsPrefix(events []string, prefix string) bool {
+	for _, event := range events {
+		if strings.HasPrefix(event, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,161 @@
+package gateway
+
+import (
+	"fmt"
+	"net/netip"
+
+	"github.com/coredns/coredns/request"
+	"github.com/miekg/dns"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// regionKey is the well-known label placing an object in a region, it's read as an annotation too
+const regionKey = "topology.kubernetes.io/region"
+
+// region is a named set of client networks
+type region struct {
+	name     string
+	prefixes []netip.Prefix
+}
+
+// regionMap maps client networks to regions, the most specific network wins
+type regionMap []region
+
+func parseRegion(args []string) (region, error) {
+	if len(args) < 2 {
+		return region{}, fmt.Errorf("region needs a name and at least one CIDR")
+	}
+	r := region{name: args[0]}
+	for _, arg := range args[1:] {
+		prefix, err := netip.ParsePrefix(arg)
+		if err != nil {
+			return region{}, fmt.Errorf("region must be a list of CIDRs: %s", arg)
+		}
+		r.prefixes = append(r.prefixes, prefix.Masked())
+	}
+	return r, nil
+}
+
+// lookup returns the region of a client address and the length of the network it matched, or an
+// empty name if it isn't in any region
+func (m regionMap) lookup(addr netip.Addr) (name string, bits int) {
+	bits = -1
+	for _, r := range m {
+		for _, prefix := range r.prefixes {
+			if prefix.Bits() > bits && prefix.Contains(addr) {
+				name, bits = r.name, prefix.Bits()
+			}
+		}
+	}
+	return name, bits
+}
+
+// objectRegion returns the region of an object from its topology label or annotation, if any
+func objectRegion(obj metav1.Object) string {
+	if region := obj.GetLabels()[regionKey]; region != "" {
+		return region
+	}
+	return obj.GetAnnotations()[regionKey]
+}
+
+// addrRegion returns the region of an address of a result: the one of the object it was inherited
+// from, or else the one of the object itself
+func (r lookupResult) addrRegion(addr netip.Addr) string {
+	if region, ok := r.regions[addr]; ok {
+		return region
+	}
+	if r.object == nil {
+		return ""
+	}
+	return objectRegion(r.object)
+}
+
+// nearest returns the addresses in a region, or all of them if none is
+func nearest(addrs []netip.Addr, regions map[netip.Addr]string, name string) []netip.Addr {
+	var near []netip.Addr
+	for _, addr := range addrs {
+		if regions[addr] == name {
+			near = append(near, addr)
+		}
+	}
+	if len(near) == 0 {
+		return addrs
+	}
+	return near
+}
+
+// clientSubnet returns the address the region of a query is picked by: the one of its EDNS Client
+// Subnet option, or else the source address. The option is returned as well, if the client sent one.
+func clientSubnet(state request.Request) (netip.Addr, *dns.EDNS0_SUBNET) {
+	var ecs *dns.EDNS0_SUBNET
+	if opt := state.Req.IsEdns0(); opt != nil {
+		for _, o := range opt.Option {
+			if subnet, ok := o.(*dns.EDNS0_SUBNET); ok {
+				ecs = subnet
+				break
+			}
+		}
+	}
+
+	// a source prefix of 0 asks for the client address not to be used
+	if ecs != nil && ecs.SourceNetmask > 0 {
+		if addr, ok := netip.AddrFromSlice(ecs.Address); ok {
+			return addr.Unmap(), ecs
+		}
+	}
+	addr, _ := netip.ParseAddr(state.IP())
+	return addr.Unmap(), ecs
+}
+
+// nearestAddrs keeps the addresses of an answer in the region of the client, for each address family.
+// It also returns the ECS option of the reply, whose scope covers the clients that get the same answer.
+func (gw *Gateway) nearestAddrs(state request.Request, ans *answer) ([]netip.Addr, []netip.Addr, *dns.EDNS0_SUBNET) {
+	var ipv4, ipv6 []netip.Addr
+	var regions map[netip.Addr]string
+	if ans != nil {
+		ipv4, ipv6, regions = ans.ipv4, ans.ipv6, ans.regions
+	}
+	if len(gw.regions) == 0 {
+		return ipv4, ipv6, nil
+	}
+
+	addr, ecs := clientSubnet(state)
+	var scope uint8
+	// answers depend on the client only if some of their addresses are in a region
+	if len(regions) > 0 {
+		name, bits := gw.regions.lookup(addr)
+		if name != "" {
+			ipv4, ipv6 = nearest(ipv4, regions, name), nearest(ipv6, regions, name)
+			scope = uint8(bits)
+		} else if ecs != nil {
+			// clients outside of any region get every address, only the same subnet is known to as well
+			scope = ecs.SourceNetmask
+		}
+	}
+
+	if ecs == nil {
+		return ipv4, ipv6, nil
+	}
+	// the scope must be 0 when the client didn't send its subnet (rfc7871 #7.2.1), the answer only
+	// depends on its source address then
+	if ecs.SourceNetmask == 0 {
+		scope = 0
+	}
+	reply := *ecs
+	reply.SourceScope = scope
+	return ipv4, ipv6, &reply
+}
+
+// setClientSubnet adds the ECS option to a reply
+func setClientSubnet(state request.Request, m *dns.Msg, ecs *dns.EDNS0_SUBNET) {
+	if ecs == nil {
+		return
+	}
+	opt := m.IsEdns0()
+	if opt == nil {
+		req := state.Req.IsEdns0()
+		m.SetEdns0(req.UDPSize(), req.Do())
+		opt = m.IsEdns0()
+	}
+	opt.Option = append(opt.Option, ecs)
+}
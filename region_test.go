@@ -0,0 +1,158 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	gatewayapi_v1 "sigs.k8s.io/gateway-api/apis/v1"
+)
+
+func TestRegionMap(t *testing.T) {
+	regions := regionMap{
+		{name: "eu", prefixes: []netip.Prefix{netip.MustParsePrefix("198.51.100.0/24"), netip.MustParsePrefix("10.0.0.0/8")}},
+		{name: "us", prefixes: []netip.Prefix{netip.MustParsePrefix("203.0.113.0/24"), netip.MustParsePrefix("10.1.0.0/16")}},
+	}
+
+	tests := []struct {
+		addr string
+		name string
+		bits int
+	}{
+		{"198.51.100.7", "eu", 24},
+		{"10.2.0.1", "eu", 8},
+		{"10.1.0.1", "us", 16},
+		{"192.0.2.1", "", -1},
+	}
+	for i, tc := range tests {
+		if name, bits := regions.lookup(netip.MustParseAddr(tc.addr)); name != tc.name || bits != tc.bits {
+			t.Errorf("Test %d: expected region %q/%d, got %q/%d", i, tc.name, tc.bits, name, bits)
+		}
+	}
+
+	addrs := []netip.Addr{netip.MustParseAddr("198.51.100.1"), netip.MustParseAddr("203.0.113.1")}
+	placed := map[netip.Addr]string{addrs[0]: "eu", addrs[1]: "us"}
+	if near := nearest(addrs, placed, "us"); fmt.Sprint(near) != "[203.0.113.1]" {
+		t.Errorf("Expected the address in us, got %v", near)
+	}
+	if near := nearest(addrs, placed, "ap"); len(near) != 2 {
+		t.Errorf("Expected all addresses without any in the region, got %v", near)
+	}
+}
+
+func TestRouteRegion(t *testing.T) {
+	gateways := cache.NewSharedIndexInformer(&cache.ListWatch{}, &gatewayapi_v1.Gateway{}, 0, cache.Indexers{gatewayUniqueIndex: gatewayIndexFunc})
+	for name, region := range map[string]string{"gw-eu": "eu", "gw-any": ""} {
+		gateway := &gatewayapi_v1.Gateway{
+			ObjectMeta: meta.ObjectMeta{Name: name, Namespace: "ns1", Labels: map[string]string{"app": name}},
+			Status:     gatewayapi_v1.GatewayStatus{Addresses: []gatewayapi_v1.GatewayStatusAddress{{Value: "192.0.2.1"}}},
+		}
+		if region != "" {
+			gateway.Labels[regionKey] = region
+			gateway.Status.Addresses[0].Value = "198.51.100.1"
+		}
+		// the topology label must survive the transform of the cache
+		stripped, _ := stripObject(gateway)
+		gateways.GetIndexer().Add(stripped)
+	}
+
+	refs := []gatewayapi_v1.ParentReference{{Name: "gw-eu"}, {Name: "gw-any"}}
+	found := lookupGateways(gateways, refs, "ns1", nil)
+	if region := found.addrRegion(netip.MustParseAddr("198.51.100.1")); region != "eu" {
+		t.Errorf("Expected the address of gw-eu in eu, got %q", region)
+	}
+	if region := found.addrRegion(netip.MustParseAddr("192.0.2.1")); region != "" {
+		t.Errorf("Expected the address of gw-any in no region, got %q", region)
+	}
+}
+
+func TestServeRegion(t *testing.T) {
+	gw := newGateway()
+	gw.Zones = []string{"example.com."}
+	gw.Controller = newSyncedController()
+	gw.Next = test.NextHandler(dns.RcodeSuccess, nil)
+	gw.regions = regionMap{
+		{name: "eu", prefixes: []netip.Prefix{netip.MustParsePrefix("192.0.2.0/25")}},
+		{name: "us", prefixes: []netip.Prefix{netip.MustParsePrefix("192.0.2.128/25")}},
+		// test.ResponseWriter queries from 10.240.0.1
+		{name: "lab", prefixes: []netip.Prefix{netip.MustParsePrefix("10.240.0.0/16")}},
+	}
+
+	clearLookupFuncs()
+	lookupResource("Gateway").setLookup("", gw, func(keys []string) (results []lookupResult) {
+		for _, key := range keys {
+			if key == "app.example.com" {
+				// objects are placed in a region by their topology label or annotation
+				eu := &gatewayapi_v1.Gateway{ObjectMeta: meta.ObjectMeta{Name: "eu", Labels: map[string]string{regionKey: "eu"}}}
+				us := &gatewayapi_v1.Gateway{ObjectMeta: meta.ObjectMeta{Name: "us", Annotations: map[string]string{regionKey: "us"}}}
+				results = append(results,
+					lookupResult{kind: "Gateway", object: eu, addrs: []netip.Addr{netip.MustParseAddr("198.51.100.1")}},
+					lookupResult{kind: "Gateway", object: us, addrs: []netip.Addr{netip.MustParseAddr("203.0.113.1")}},
+				)
+			}
+		}
+		return results
+	}, func() []string { return []string{"app.example.com"} })
+	gw.buildSnapshot()
+
+	tests := []struct {
+		ecs      string
+		netmask  uint8
+		expected string
+		scope    int
+	}{
+		{"192.0.2.10", 24, "[198.51.100.1]", 25},
+		{"192.0.2.200", 32, "[203.0.113.1]", 25},
+		{"100.64.0.1", 24, "[198.51.100.1 203.0.113.1]", 24},
+		{"", 0, "[198.51.100.1 203.0.113.1]", -1},
+		// a source prefix of 0 falls back to the source address in lab, the scope must still be 0
+		{"0.0.0.0", 0, "[198.51.100.1 203.0.113.1]", 0},
+	}
+
+	for i, tc := range tests {
+		r := new(dns.Msg)
+		r.SetQuestion("app.example.com.", dns.TypeA)
+		if tc.ecs != "" {
+			r.SetEdns0(4096, false)
+			r.IsEdns0().Option = append(r.IsEdns0().Option, &dns.EDNS0_SUBNET{
+				Code:          dns.EDNS0SUBNET,
+				Family:        1,
+				SourceNetmask: tc.netmask,
+				Address:       net.ParseIP(tc.ecs).To4(),
+			})
+		}
+		w := dnstest.NewRecorder(&test.ResponseWriter{})
+
+		if _, err := gw.ServeDNS(context.TODO(), w, r); err != nil {
+			t.Errorf("Test %d: expected no error, got %v", i, err)
+			continue
+		}
+
+		var addrs []string
+		for _, rr := range w.Msg.Answer {
+			addrs = append(addrs, rr.(*dns.A).A.String())
+		}
+		if fmt.Sprint(addrs) != tc.expected {
+			t.Errorf("Test %d: expected addresses %s, got %v", i, tc.expected, addrs)
+		}
+
+		scope := -1
+		if opt := w.Msg.IsEdns0(); opt != nil {
+			for _, o := range opt.Option {
+				if ecs, ok := o.(*dns.EDNS0_SUBNET); ok {
+					scope = int(ecs.SourceScope)
+				}
+			}
+		}
+		if scope != tc.scope {
+			t.Errorf("Test %d: expected ECS scope %d, got %d", i, tc.scope, scope)
+		}
+	}
+}
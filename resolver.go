@@ -0,0 +1,270 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	core "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	"k8s.io/client-go/tools/cache"
+	gatewayapi_v1 "sigs.k8s.io/gateway-api/apis/v1"
+)
+
+const (
+	resolverMinTTL      = 5 * time.Second
+	resolverMaxTTL      = time.Hour
+	resolverSystemTTL   = 30 * time.Second
+	resolverRetry       = 5 * time.Second
+	resolverTimeout     = 5 * time.Second
+	resolverIdleTimeout = time.Hour
+	resolverInterval    = time.Second
+)
+
+// hostResolver resolves the hostnames found in load balancer statuses in the background,
+// so that DNS queries never wait on the upstream resolver for names that are already cached
+type hostResolver struct {
+	sync.Mutex
+	upstream string
+	client   *dns.Client
+	entries  map[string]*resolverEntry
+	resolve  func(ctx context.Context, hostname string) ([]netip.Addr, time.Duration, error)
+	// onChange is called whenever the addresses of a hostname change
+	onChange func()
+}
+
+type resolverEntry struct {
+	addrs     []netip.Addr
+	refreshAt time.Time
+	lastUsed  time.Time
+	resolving bool
+	// resolved is closed once the first resolution attempt has finished
+	resolved chan struct{}
+}
+
+// newHostResolver returns a resolver querying the upstream server, or the system resolver if upstream is empty
+func newHostResolver(upstream string) *hostResolver {
+	r := &hostResolver{
+		upstream: upstream,
+		client:   &dns.Client{Timeout: resolverTimeout},
+		entries:  make(map[string]*resolverEntry),
+	}
+
+	r.resolve = r.exchange
+	if upstream == "" {
+		r.resolve = systemResolve
+	}
+
+	return r
+}
+
+// lookup returns the cached addresses of a hostname, without waiting on the upstream. Names that
+// have not been seen before have no addresses until they are resolved in the background, which
+// then reports the change.
+func (r *hostResolver) lookup(hostname string) []netip.Addr {
+	if r == nil {
+		return nil
+	}
+
+	r.Lock()
+	entry, ok := r.entries[hostname]
+	if !ok {
+		entry = r.add(hostname)
+		resolverRequests.WithLabelValues("miss").Inc()
+	} else {
+		resolverRequests.WithLabelValues("hit").Inc()
+	}
+	entry.lastUsed = time.Now()
+	defer r.Unlock()
+	return entry.addrs
+}
+
+// prefetch starts resolving a hostname in the background unless it's already cached
+func (r *hostResolver) prefetch(hostname string) {
+	if r == nil || hostname == "" {
+		return
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	if _, ok := r.entries[hostname]; !ok {
+		r.add(hostname).lastUsed = time.Now()
+	}
+}
+
+// add must be called with the lock held
+func (r *hostResolver) add(hostname string) *resolverEntry {
+	entry := &resolverEntry{resolving: true, resolved: make(chan struct{})}
+	r.entries[hostname] = entry
+	go r.refresh(hostname, entry)
+	return entry
+}
+
+func (r *hostResolver) refresh(hostname string, entry *resolverEntry) {
+	ctx, cancel := context.WithTimeout(context.Background(), resolverTimeout)
+	defer cancel()
+
+	addrs, ttl, err := r.resolve(ctx, hostname)
+
+	r.Lock()
+	var changed bool
+	entry.resolving = false
+	if err != nil {
+		// keep serving the previous addresses until the upstream recovers
+		log.Warningf("Failed to resolve hostname %s: %s", hostname, err)
+		resolverFailures.Inc()
+		entry.refreshAt = time.Now().Add(resolverRetry)
+	} else {
+		ttl = min(max(ttl, resolverMinTTL), resolverMaxTTL)
+		// upstreams rotate the order of addresses, which isn't a change worth reporting
+		slices.SortFunc(addrs, netip.Addr.Compare)
+		changed = !slices.Equal(entry.addrs, addrs)
+		entry.addrs = addrs
+		// refresh ahead of the expiry, so that the cache never serves an outdated answer
+		entry.refreshAt = time.Now().Add(ttl * 4 / 5)
+	}
+
+	select {
+	case <-entry.resolved:
+	default:
+		close(entry.resolved)
+	}
+	r.Unlock()
+
+	if changed && r.onChange != nil {
+		r.onChange()
+	}
+}
+
+// run refreshes the cached hostnames before they expire and drops the ones that are no longer queried
+func (r *hostResolver) run(ctx context.Context) {
+	ticker := time.NewTicker(resolverInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			r.Lock()
+			for hostname, entry := range r.entries {
+				if entry.resolving {
+					continue
+				}
+				if now.Sub(entry.lastUsed) > resolverIdleTimeout {
+					delete(r.entries, hostname)
+					continue
+				}
+				if now.After(entry.refreshAt) {
+					entry.resolving = true
+					go r.refresh(hostname, entry)
+				}
+			}
+			r.Unlock()
+		}
+	}
+}
+
+// exchange queries the upstream server for both address families and returns the lowest TTL.
+// Names without any address are cached for the negative TTL of the SOA in the authority section
+// (rfc2308 #5), or retried soon without one, as a load balancer hostname may not resolve yet.
+func (r *hostResolver) exchange(ctx context.Context, hostname string) (addrs []netip.Addr, ttl time.Duration, err error) {
+	ttl = resolverMaxTTL
+	negativeTTL := resolverRetry
+	var hasSOA bool
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		m := new(dns.Msg)
+		m.SetQuestion(dns.Fqdn(hostname), qtype)
+
+		resp, _, err := r.client.ExchangeContext(ctx, m, r.upstream)
+		if err != nil {
+			return nil, 0, err
+		}
+		if resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError {
+			return nil, 0, fmt.Errorf("upstream %s answered %s", r.upstream, dns.RcodeToString[resp.Rcode])
+		}
+
+		for _, rr := range resp.Answer {
+			var ip net.IP
+			switch rr := rr.(type) {
+			case *dns.A:
+				ip = rr.A
+			case *dns.AAAA:
+				ip = rr.AAAA
+			default:
+				continue
+			}
+			if addr, ok := netip.AddrFromSlice(ip); ok {
+				addrs = append(addrs, addr.Unmap())
+				ttl = min(ttl, time.Duration(rr.Header().Ttl)*time.Second)
+			}
+		}
+		for _, rr := range resp.Ns {
+			if soa, ok := rr.(*dns.SOA); ok {
+				soaTTL := time.Duration(min(soa.Hdr.Ttl, soa.Minttl)) * time.Second
+				if !hasSOA || soaTTL < negativeTTL {
+					negativeTTL = soaTTL
+				}
+				hasSOA = true
+			}
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, max(negativeTTL, resolverMinTTL), nil
+	}
+	return addrs, ttl, nil
+}
+
+// systemResolve uses the resolver of the operating system, which doesn't expose TTLs
+func systemResolve(ctx context.Context, hostname string) ([]netip.Addr, time.Duration, error) {
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", hostname)
+	if err != nil {
+		return nil, 0, err
+	}
+	for i := range addrs {
+		addrs[i] = addrs[i].Unmap()
+	}
+	return addrs, resolverSystemTTL, nil
+}
+
+// prefetchHandler resolves the hostnames from object statuses as soon as they show up
+func (r *hostResolver) prefetchHandler() cache.ResourceEventHandler {
+	prefetch := func(obj interface{}) {
+		for _, hostname := range statusHostnames(obj) {
+			r.prefetch(hostname)
+		}
+	}
+
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: prefetch,
+		UpdateFunc: func(_, newObj interface{}) {
+			prefetch(newObj)
+		},
+	}
+}
+
+// statusHostnames returns the load balancer hostnames from the status of an object
+func statusHostnames(obj interface{}) (hostnames []string) {
+	switch obj := obj.(type) {
+	case *core.Service:
+		for _, ingress := range obj.Status.LoadBalancer.Ingress {
+			hostnames = append(hostnames, ingress.Hostname)
+		}
+	case *networking.Ingress:
+		for _, ingress := range obj.Status.LoadBalancer.Ingress {
+			hostnames = append(hostnames, ingress.Hostname)
+		}
+	case *gatewayapi_v1.Gateway:
+		for _, addr := range obj.Status.Addresses {
+			if addr.Type != nil && *addr.Type == gatewayapi_v1.HostnameAddressType {
+				hostnames = append(hostnames, addr.Value)
+			}
+		}
+	}
+	return hostnames
+}
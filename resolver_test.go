@@ -0,0 +1,154 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestResolverExchange(t *testing.T) {
+	upstream := dnstest.NewServer(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		switch r.Question[0].Qtype {
+		case dns.TypeA:
+			m.Answer = append(m.Answer, test.A("lb.example.net. 120 IN A 192.0.2.1"))
+		case dns.TypeAAAA:
+			m.Answer = append(m.Answer, test.AAAA("lb.example.net. 30 IN AAAA 2001:db8::1"))
+		}
+		w.WriteMsg(m)
+	})
+	defer upstream.Close()
+
+	r := newHostResolver(upstream.Addr)
+	addrs, ttl, err := r.resolve(context.Background(), "lb.example.net")
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if len(addrs) != 2 || addrs[0] != netip.MustParseAddr("192.0.2.1") || addrs[1] != netip.MustParseAddr("2001:db8::1") {
+		t.Errorf("Unexpected addresses %v", addrs)
+	}
+	if ttl != 30*time.Second {
+		t.Errorf("Expected the lowest TTL of 30s, got %s", ttl)
+	}
+
+	r.lookup("lb.example.net")
+	<-r.entries["lb.example.net"].resolved
+	if addrs := r.lookup("lb.example.net"); len(addrs) != 2 {
+		t.Errorf("Expected 2 cached addresses, got %v", addrs)
+	}
+}
+
+func TestResolverNegative(t *testing.T) {
+	upstream := dnstest.NewServer(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		switch r.Question[0].Name {
+		case "missing.example.net.":
+			m.Rcode = dns.RcodeNameError
+			m.Ns = append(m.Ns, test.SOA("example.net. 300 IN SOA ns.example.net. hostmaster.example.net. 1 7200 1800 86400 30"))
+		case "nosoa.example.net.":
+		case "short.example.net.":
+			m.Ns = append(m.Ns, test.SOA("example.net. 300 IN SOA ns.example.net. hostmaster.example.net. 1 7200 1800 86400 1"))
+		}
+		w.WriteMsg(m)
+	})
+	defer upstream.Close()
+
+	r := newHostResolver(upstream.Addr)
+	tests := []struct {
+		hostname string
+		ttl      time.Duration
+	}{
+		{"missing.example.net", 30 * time.Second},
+		{"nosoa.example.net", resolverRetry},
+		{"short.example.net", resolverMinTTL},
+	}
+	for i, tc := range tests {
+		addrs, ttl, err := r.resolve(context.Background(), tc.hostname)
+		if err != nil || len(addrs) != 0 {
+			t.Errorf("Test %d: expected no addresses and no error, got %v and %v", i, addrs, err)
+		}
+		if ttl != tc.ttl {
+			t.Errorf("Test %d: expected a negative TTL of %s, got %s", i, tc.ttl, ttl)
+		}
+	}
+}
+
+func TestResolverStale(t *testing.T) {
+	var fail atomic.Bool
+	r := newHostResolver("")
+	r.resolve = func(_ context.Context, _ string) ([]netip.Addr, time.Duration, error) {
+		if fail.Load() {
+			return nil, 0, errors.New("upstream unavailable")
+		}
+		return []netip.Addr{netip.MustParseAddr("192.0.2.1")}, time.Minute, nil
+	}
+
+	r.lookup("lb.example.net")
+	<-r.entries["lb.example.net"].resolved
+	hits, failures := testutil.ToFloat64(resolverRequests.WithLabelValues("hit")), testutil.ToFloat64(resolverFailures)
+	if addrs := r.lookup("lb.example.net"); len(addrs) != 1 {
+		t.Fatalf("Expected 1 address, got %v", addrs)
+	}
+
+	// a failed refresh keeps the previous answer and retries soon
+	fail.Store(true)
+	entry := r.entries["lb.example.net"]
+	r.refresh("lb.example.net", entry)
+	if addrs := r.lookup("lb.example.net"); len(addrs) != 1 {
+		t.Errorf("Expected the stale address to be kept, got %v", addrs)
+	}
+	if value := testutil.ToFloat64(resolverRequests.WithLabelValues("hit")) - hits; value != 2 {
+		t.Errorf("Expected 2 cache hits, got %v", value)
+	}
+	if value := testutil.ToFloat64(resolverFailures) - failures; value != 1 {
+		t.Errorf("Expected 1 failed resolution, got %v", value)
+	}
+	if time.Until(entry.refreshAt) > resolverRetry {
+		t.Errorf("Expected a retry within %s, got %s", resolverRetry, time.Until(entry.refreshAt))
+	}
+}
+
+func TestResolverWait(t *testing.T) {
+	release := make(chan struct{})
+	changed := make(chan struct{}, 1)
+	r := newHostResolver("")
+	r.onChange = func() { changed <- struct{}{} }
+	r.resolve = func(_ context.Context, _ string) ([]netip.Addr, time.Duration, error) {
+		<-release
+		return []netip.Addr{netip.MustParseAddr("192.0.2.1")}, time.Minute, nil
+	}
+
+	// queries never wait on the upstream, the change is reported once the name is resolved
+	start := time.Now()
+	if addrs := r.lookup("slow.example.net"); len(addrs) != 0 {
+		t.Errorf("Expected no addresses before the name is resolved, got %v", addrs)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("Expected lookup not to wait for the upstream, took %s", elapsed)
+	}
+
+	close(release)
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected a change once the name is resolved")
+	}
+	if addrs := r.lookup("slow.example.net"); len(addrs) != 1 {
+		t.Errorf("Expected 1 address once resolved, got %v", addrs)
+	}
+
+	var nilResolver *hostResolver
+	if addrs := nilResolver.lookup("slow.example.net"); addrs != nil {
+		t.Errorf("Expected a nil resolver to return no addresses, got %v", addrs)
+	}
+}
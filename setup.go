@@ -2,8 +2,12 @@ package gateway
 
 import (
 	"context"
-
+	"math"
+	"net"
+	"net/netip"
+	"slices"
 	"strconv"
+	"strings"
 
 	"github.com/coredns/caddy"
 	"github.com/coredns/coredns/core/dnsserver"
@@ -26,10 +30,22 @@ func setup(c *caddy.Controller) error {
 		return plugin.Error(thisPlugin, err)
 	}
 
-	err = gw.RunKubeController(context.Background())
-	if err != nil {
-		return plugin.Error(thisPlugin, err)
-	}
+	// controllers are started with the server, and only stopped after a reloaded instance has
+	// started, so that it can take over the informers of this one if its configuration is the same
+	c.OnStartup(func() error {
+		if err := gw.RunKubeController(context.Background()); err != nil {
+			return plugin.Error(thisPlugin, err)
+		}
+		return nil
+	})
+	c.OnShutdown(gw.StopKubeController)
+
+	// the debug listener is closed before a reload and reopened if the reload fails, as its address
+	// may be taken over by the reloaded instance
+	c.OnStartup(gw.startDebug)
+	c.OnRestart(gw.stopDebug)
+	c.OnRestartFailed(gw.startDebug)
+	c.OnFinalShutdown(gw.stopDebug)
 	gw.ExternalAddrFunc = gw.SelfAddress
 
 	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
@@ -40,8 +56,12 @@ func setup(c *caddy.Controller) error {
 	return nil
 }
 
+// zoneOption applies a single configuration option to the settings of a zone
+type zoneOption func(*zoneConfig)
+
 func parse(c *caddy.Controller) (*Gateway, error) {
 	gw := newGateway()
+	zoneOptions := make(map[string][]zoneOption)
 
 	for c.Next() {
 		zones := c.RemainingArgs()
@@ -52,63 +72,273 @@ func parse(c *caddy.Controller) (*Gateway, error) {
 			copy(gw.Zones, c.ServerBlockKeys)
 		}
 
-		for i, str := range gw.Zones {
-			if host := plugin.Host(str).NormalizeExact(); len(host) != 0 {
-				gw.Zones[i] = host[0]
-			}
-		}
+		normalizeZones(gw.Zones)
 
 		for c.NextBlock() {
 			switch c.Val() {
 			case "fallthrough":
 				gw.Fall.SetZonesFromArgs(c.RemainingArgs())
-			case "secondary":
+			case "conflict":
+				policy, err := parseConflictPolicy(c.RemainingArgs())
+				if err != nil {
+					return nil, c.Err(err.Error())
+				}
+				gw.conflict = policy
+			case "kubeconfig":
 				args := c.RemainingArgs()
-				if len(args) == 0 {
+				if len(args) == 0 || len(args) > 2 {
 					return nil, c.ArgErr()
 				}
-				gw.secondNS = args[0]
-			case "resources":
-				args := c.RemainingArgs()
-
-				gw.updateResources(args)
-
-				if len(args) == 0 {
-					return nil, c.Errf("Incorrectly formated 'resource' parameter")
+				var context string
+				if len(args) == 2 {
+					context = args[1]
+				}
+				clusters, err := kubeconfigClusters(args[0], context)
+				if err != nil {
+					return nil, c.Err(err.Error())
+				}
+				for _, cluster := range clusters {
+					if slices.ContainsFunc(gw.clusters, func(c clusterConfig) bool { return c.name == cluster.name }) {
+						return nil, c.Errf("cluster '%s' is configured more than once", cluster.name)
+					}
+					gw.clusters = append(gw.clusters, cluster)
+				}
+			case "clusters":
+				policy, err := parseClusterPolicy(c.RemainingArgs())
+				if err != nil {
+					return nil, c.Err(err.Error())
 				}
-			case "ttl":
+				gw.clusterPolicy = policy
+			case "resolver":
 				args := c.RemainingArgs()
-				if len(args) == 0 {
+				if len(args) != 1 {
 					return nil, c.ArgErr()
 				}
-				t, err := strconv.Atoi(args[0])
-				if err != nil {
-					return nil, err
+				upstream := args[0]
+				if _, _, err := net.SplitHostPort(upstream); err != nil {
+					upstream = net.JoinHostPort(upstream, "53")
 				}
-				if t < 0 || t > 3600 {
-					return nil, c.Errf("ttl must be in range [0, 3600]: %d", t)
+				if _, err := netip.ParseAddrPort(upstream); err != nil {
+					return nil, c.Errf("resolver must be an IP address with an optional port: %s", args[0])
 				}
-				gw.ttlLow = uint32(t)
-			case "apex":
+				gw.resolverUpstream = upstream
+			case "debug":
 				args := c.RemainingArgs()
-				if len(args) == 0 {
+				if len(args) != 1 {
 					return nil, c.ArgErr()
 				}
-				gw.apex = args[0]
-			case "kubeconfig":
+				if _, _, err := net.SplitHostPort(args[0]); err != nil {
+					return nil, c.Errf("debug address must be HOST:PORT or :PORT: %s", args[0])
+				}
+				gw.debugAddr = args[0]
+			case "publish":
+				args := c.RemainingArgs()
+				if len(args) > 1 {
+					return nil, c.ArgErr()
+				}
+				gw.publish = true
+				if len(args) == 1 {
+					gw.publishNamespace = args[0]
+				}
+			case "view":
 				args := c.RemainingArgs()
 				if len(args) == 0 {
 					return nil, c.ArgErr()
 				}
-				gw.configFile = args[0]
-				if len(args) == 2 {
-					gw.configContext = args[1]
+				for _, arg := range args {
+					prefix, err := netip.ParsePrefix(arg)
+					if err != nil {
+						return nil, c.Errf("view must be a list of CIDRs: %s", arg)
+					}
+					gw.internalNets = append(gw.internalNets, prefix.Masked())
+				}
+			case "region":
+				r, err := parseRegion(c.RemainingArgs())
+				if err != nil {
+					return nil, c.Err(err.Error())
+				}
+				if slices.ContainsFunc(gw.regions, func(other region) bool { return other.name == r.name }) {
+					return nil, c.Errf("region '%s' is configured more than once", r.name)
+				}
+				gw.regions = append(gw.regions, r)
+			case "healthcheck":
+				mode, err := parseHealthMode(c.RemainingArgs())
+				if err != nil {
+					return nil, c.Err(err.Error())
+				}
+				gw.health = mode
+			case "partial":
+				if c.NextArg() {
+					return nil, c.ArgErr()
+				}
+				gw.partial = true
+			case "zone":
+				zones := c.RemainingArgs()
+				if len(zones) == 0 {
+					return nil, c.ArgErr()
+				}
+				normalizeZones(zones)
+
+				for _, zone := range zones {
+					if plugin.Zones(gw.Zones).Matches(zone) != zone {
+						return nil, c.Errf("zone '%s' is not one of the plugin zones %v", zone, gw.Zones)
+					}
+				}
+
+				options, err := parseZoneBlock(c)
+				if err != nil {
+					return nil, err
+				}
+				for _, zone := range zones {
+					zoneOptions[zone] = append(zoneOptions[zone], options...)
 				}
 			default:
-				return nil, c.Errf("Unknown property '%s'", c.Val())
+				option, err := parseZoneOption(c)
+				if err != nil {
+					return nil, err
+				}
+				option(&gw.zoneConfig)
 			}
 		}
 	}
+
+	// zone blocks are applied on top of the plugin-wide settings, regardless of where they appear
+	for zone, options := range zoneOptions {
+		zc := gw.zoneConfig
+		for _, option := range options {
+			option(&zc)
+		}
+		gw.zoneConfigs[zone] = &zc
+	}
+
+	if err := gw.clusterPolicy.validate(gw.clusters); err != nil {
+		return nil, c.Err(err.Error())
+	}
+	// results are only tagged with their cluster when there are several of them
+	if len(gw.clusters) == 1 {
+		gw.clusters[0].name = ""
+	}
+
 	return gw, nil
 
 }
+
+// parseZoneBlock parses the options inside of a `zone ZONES... { }` block
+func parseZoneBlock(c *caddy.Controller) ([]zoneOption, error) {
+	if !c.NextArg() || c.Val() != "{" {
+		return nil, c.Err("Expected '{' to open a zone block")
+	}
+
+	var options []zoneOption
+	for c.Next() {
+		if c.Val() == "}" {
+			return options, nil
+		}
+		option, err := parseZoneOption(c)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, option)
+	}
+
+	return nil, c.Err("Unterminated zone block")
+}
+
+// parseZoneOption parses the options that can be set both globally and per zone
+func parseZoneOption(c *caddy.Controller) (zoneOption, error) {
+	switch c.Val() {
+	case "secondary":
+		args := c.RemainingArgs()
+		if len(args) == 0 {
+			return nil, c.ArgErr()
+		}
+		return func(zc *zoneConfig) { zc.secondNS = args[0] }, nil
+	case "resources":
+		args := c.RemainingArgs()
+
+		if len(args) == 0 {
+			return nil, c.Errf("Incorrectly formated 'resource' parameter")
+		}
+		return func(zc *zoneConfig) { zc.updateResources(args) }, nil
+	case "ttl":
+		args := c.RemainingArgs()
+		if len(args) == 0 {
+			return nil, c.ArgErr()
+		}
+		t, err := strconv.Atoi(args[0])
+		if err != nil {
+			return nil, err
+		}
+		if t < 0 || t > 3600 {
+			return nil, c.Errf("ttl must be in range [0, 3600]: %d", t)
+		}
+		return func(zc *zoneConfig) { zc.ttlLow = uint32(t) }, nil
+	case "ttl_bounds":
+		args := c.RemainingArgs()
+		if len(args) != 2 {
+			return nil, c.ArgErr()
+		}
+		bounds := make([]uint32, 2)
+		for i, arg := range args {
+			t, err := strconv.Atoi(arg)
+			if err != nil {
+				return nil, err
+			}
+			if t < 0 || t > math.MaxInt32 {
+				return nil, c.Errf("ttl bounds must be in range [0, %d]: %d", math.MaxInt32, t)
+			}
+			bounds[i] = uint32(t)
+		}
+		if bounds[0] > bounds[1] {
+			return nil, c.Errf("minimum ttl %d is larger than maximum ttl %d", bounds[0], bounds[1])
+		}
+		return func(zc *zoneConfig) {
+			zc.ttlMin = bounds[0]
+			zc.ttlMax = bounds[1]
+		}, nil
+	case "apex":
+		args := c.RemainingArgs()
+		if len(args) == 0 {
+			return nil, c.ArgErr()
+		}
+		return func(zc *zoneConfig) { zc.apex = args[0] }, nil
+	case "merge":
+		weights := make(map[string]int)
+		for _, arg := range c.RemainingArgs() {
+			resource, value, found := strings.Cut(arg, "=")
+			if !found || lookupResource(resource) == nil {
+				return nil, c.Errf("merge weights must be in the RESOURCE=WEIGHT format: %s", arg)
+			}
+			weight, err := strconv.Atoi(value)
+			if err != nil || weight < 0 {
+				return nil, c.Errf("merge weight must be a non-negative integer: %s", arg)
+			}
+			weights[resource] = weight
+		}
+		return func(zc *zoneConfig) {
+			zc.merge = true
+			zc.weights = weights
+		}, nil
+	case "allow_cidr", "deny_cidr":
+		option := c.Val()
+		prefixes, err := parsePrefixes(c.RemainingArgs())
+		if err != nil {
+			return nil, c.Errf("%s: %s", option, err)
+		}
+		// zone blocks copy the plugin-wide lists, which must not be appended to in place
+		if option == "allow_cidr" {
+			return func(zc *zoneConfig) { zc.allowCIDRs = append(slices.Clip(zc.allowCIDRs), prefixes...) }, nil
+		}
+		return func(zc *zoneConfig) { zc.denyCIDRs = append(slices.Clip(zc.denyCIDRs), prefixes...) }, nil
+	}
+
+	return nil, c.Errf("Unknown property '%s'", c.Val())
+}
+
+func normalizeZones(zones []string) {
+	for i, str := range zones {
+		if host := plugin.Host(str).NormalizeExact(); len(host) != 0 {
+			zones[i] = host[0]
+		}
+	}
+}
@@ -16,6 +16,143 @@ func TestSetup(t *testing.T) {
 		{`k8s_gateway`, false, "", 1},
 		{`k8s_gateway example.org`, false, "example.org.", 1},
 		{`k8s_gateway example.org sub.example.org`, false, "sub.example.org.", 2},
+		{`k8s_gateway example.org {
+			conflict oldest
+		}`, false, "example.org.", 1},
+		{`k8s_gateway example.org {
+			conflict namespace prod staging
+		}`, false, "example.org.", 1},
+		{`k8s_gateway example.org {
+			conflict newest
+		}`, true, "", 0},
+		{`k8s_gateway example.org internal.example.org {
+			zone internal.example.org {
+				resources Service
+				ttl 10
+			}
+		}`, false, "example.org.", 2},
+		{`k8s_gateway example.org {
+			zone other.org {
+				ttl 10
+			}
+		}`, true, "", 0},
+		{`k8s_gateway example.org {
+			zone example.org {
+				kubeconfig /dev/null
+			}
+		}`, true, "", 0},
+		{`k8s_gateway example.org {
+			zone example.org
+		}`, true, "", 0},
+		{`k8s_gateway example.org {
+			merge HTTPRoute=90 Ingress=10
+		}`, false, "example.org.", 1},
+		{`k8s_gateway example.org {
+			merge Pod=10
+		}`, true, "", 0},
+		{`k8s_gateway example.org {
+			ttl_bounds 5 300
+		}`, false, "example.org.", 1},
+		{`k8s_gateway example.org {
+			ttl_bounds 300 5
+		}`, true, "", 0},
+		{`k8s_gateway example.org {
+			ttl_bounds 5
+		}`, true, "", 0},
+		{`k8s_gateway example.org {
+			merge Ingress=-1
+		}`, true, "", 0},
+		{`k8s_gateway example.org {
+			resolver 10.0.0.10
+		}`, false, "example.org.", 1},
+		{`k8s_gateway example.org {
+			resolver [fd00::10]:5353
+		}`, false, "example.org.", 1},
+		{`k8s_gateway example.org {
+			resolver dns.example.org
+		}`, true, "", 0},
+		{`k8s_gateway example.org {
+			partial
+		}`, false, "example.org.", 1},
+		{`k8s_gateway example.org {
+			debug :8053
+		}`, false, "example.org.", 1},
+		{`k8s_gateway example.org {
+			kubeconfig /etc/kubeconfig eu
+			kubeconfig /etc/kubeconfig us
+			clusters failover us eu
+		}`, false, "example.org.", 1},
+		{`k8s_gateway example.org {
+			kubeconfig /etc/kubeconfig eu
+			kubeconfig /etc/kubeconfig us
+			clusters union
+		}`, false, "example.org.", 1},
+		{`k8s_gateway example.org {
+			kubeconfig /etc/kubeconfig eu
+			kubeconfig /etc/kubeconfig eu
+		}`, true, "", 0},
+		{`k8s_gateway example.org {
+			kubeconfig /etc/kubeconfig eu
+			kubeconfig /etc/kubeconfig us
+			clusters failover ap
+		}`, true, "", 0},
+		{`k8s_gateway example.org {
+			clusters roundrobin
+		}`, true, "", 0},
+		{`k8s_gateway example.org {
+			publish
+		}`, false, "example.org.", 1},
+		{`k8s_gateway example.org {
+			view 10.0.0.0/8 fd00::/8
+			view 192.168.0.0/16
+		}`, false, "example.org.", 1},
+		{`k8s_gateway example.org {
+			view
+		}`, true, "", 0},
+		{`k8s_gateway example.org {
+			region eu 198.51.100.0/24 2001:db8:e0::/48
+			region us 203.0.113.0/24
+		}`, false, "example.org.", 1},
+		{`k8s_gateway example.org {
+			region eu
+		}`, true, "", 0},
+		{`k8s_gateway example.org {
+			healthcheck withdraw
+		}`, false, "example.org.", 1},
+		{`k8s_gateway example.org {
+			healthcheck
+		}`, true, "", 0},
+		{`k8s_gateway example.org internal.example.org {
+			deny_cidr 10.0.0.0/8 169.254.0.0/16 fe80::/10
+			zone internal.example.org {
+				allow_cidr 10.0.0.0/8
+			}
+		}`, false, "example.org.", 2},
+		{`k8s_gateway example.org {
+			allow_cidr 10.0.0.1
+		}`, true, "", 0},
+		{`k8s_gateway example.org {
+			deny_cidr
+		}`, true, "", 0},
+		{`k8s_gateway example.org {
+			region eu 198.51.100.0/24
+			region eu 203.0.113.0/24
+		}`, true, "", 0},
+		{`k8s_gateway example.org {
+			view 10.0.0.1
+		}`, true, "", 0},
+		{`k8s_gateway example.org {
+			publish kube-system
+		}`, false, "example.org.", 1},
+		{`k8s_gateway example.org {
+			debug 8053
+		}`, true, "", 0},
+		{`k8s_gateway example.org {
+			publish kube-system dns
+		}`, true, "", 0},
+		{`k8s_gateway example.org {
+			partial yes
+		}`, true, "", 0},
 	}
 
 	for i, test := range tests {
@@ -39,3 +176,57 @@ func TestSetup(t *testing.T) {
 		}
 	}
 }
+
+func TestSetupZoneBlock(t *testing.T) {
+	c := caddy.NewTestController("dns", `k8s_gateway example.org internal.example.org {
+		zone internal.example.org {
+			resources Service
+			ttl 10
+		}
+		ttl 30
+	}`)
+	gw, err := parse(c)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	public := gw.configFor("example.org.")
+	if public.ttlLow != 30 || len(public.Resources) != len(orderedResources) {
+		t.Errorf("Expected the plugin-wide settings for example.org., got ttl %d and %d resources", public.ttlLow, len(public.Resources))
+	}
+
+	internal := gw.configFor("Internal.Example.org.")
+	if internal.ttlLow != 10 {
+		t.Errorf("Expected ttl 10 for internal.example.org., got %d", internal.ttlLow)
+	}
+	if len(internal.Resources) != 1 || internal.Resources[0].name != "Service" {
+		t.Errorf("Expected only Service resources for internal.example.org., got %v", internal.Resources)
+	}
+	if internal.apex != public.apex {
+		t.Errorf("Expected apex %s to be inherited, got %s", public.apex, internal.apex)
+	}
+}
+
+func TestSetupAddressFilters(t *testing.T) {
+	c := caddy.NewTestController("dns", `k8s_gateway example.org internal.example.org {
+		deny_cidr 10.0.0.0/8
+		zone internal.example.org {
+			deny_cidr 169.254.0.0/16
+			allow_cidr 192.0.2.0/24
+		}
+		deny_cidr fe80::/10
+	}`)
+	gw, err := parse(c)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	public := gw.configFor("example.org.")
+	if len(public.denyCIDRs) != 2 || len(public.allowCIDRs) != 0 {
+		t.Errorf("Expected the plugin-wide filters for example.org., got allow %v and deny %v", public.allowCIDRs, public.denyCIDRs)
+	}
+	internal := gw.configFor("internal.example.org.")
+	if len(internal.denyCIDRs) != 3 || len(internal.allowCIDRs) != 1 {
+		t.Errorf("Expected the zone filters on top of the plugin-wide ones, got allow %v and deny %v", internal.allowCIDRs, internal.denyCIDRs)
+	}
+}
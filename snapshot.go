@@ -0,0 +1,435 @@
+package gateway
+
+import (
+	"context"
+	"maps"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/dnsutil"
+	"github.com/miekg/dns"
+)
+
+const (
+	// snapshotDelay coalesces bursts of informer events into a single update
+	snapshotDelay = 100 * time.Millisecond
+	// snapshotResync periodically rebuilds the whole table, which also keeps resolved hostnames fresh
+	snapshotResync = 10 * time.Minute
+)
+
+// answer is the precomputed response for a single hostname
+type answer struct {
+	ttl   uint32
+	cname string
+	ipv4  []netip.Addr
+	ipv6  []netip.Addr
+	// winners are kept to shuffle weighted merges on every query
+	winners  []lookupResult
+	weighted bool
+	// internal is the answer served to the internal view, if one is configured
+	internal *answer
+	// regions holds the region of the addresses of objects placed in one
+	regions map[netip.Addr]string
+}
+
+// empty returns true if the answer has no records
+func (ans *answer) empty() bool {
+	return len(ans.ipv4) == 0 && len(ans.ipv6) == 0 && ans.cname == ""
+}
+
+// inView returns true if the answer has records in the regular or the internal view
+func (ans *answer) inView(internal bool) bool {
+	if internal && ans.internal != nil {
+		return !ans.internal.empty()
+	}
+	return !ans.empty()
+}
+
+// snapshot is an immutable table of answers keyed by the lowercased FQDN
+type snapshot struct {
+	answers map[string]*answer
+	// nonTerminals counts the answered names below each name of a zone, so that empty
+	// non-terminals get NODATA instead of NXDOMAIN
+	nonTerminals map[string]nonTerminal
+	// glue holds the addresses of the nameserver names of every zone, keyed by their FQDN
+	glue map[string]glue
+}
+
+// glue is the precomputed addresses of a nameserver name, served with apex and NS queries
+type glue struct {
+	ipv4 []netip.Addr
+	ipv6 []netip.Addr
+}
+
+// nonTerminal counts the names below a name that have records, in each view
+type nonTerminal struct {
+	regular  int
+	internal int
+}
+
+// countParents adds delta to the counts of every name between an answered name and its zone
+func (gw *Gateway) countParents(counts map[string]nonTerminal, fqdn string, ans *answer, delta int) {
+	zone := plugin.Zones(gw.Zones).Matches(fqdn)
+	regular, internal := ans.inView(false), ans.inView(true)
+
+	for name := parentName(fqdn); name != "" && name != zone && dns.IsSubDomain(zone, name); name = parentName(name) {
+		count := counts[name]
+		if regular {
+			count.regular += delta
+		}
+		if internal {
+			count.internal += delta
+		}
+		if count == (nonTerminal{}) {
+			delete(counts, name)
+		} else {
+			counts[name] = count
+		}
+	}
+}
+
+// parentName strips the first label of a name, returning an empty string for the root
+func parentName(name string) string {
+	next, end := dns.NextLabel(name, 0)
+	if end {
+		return ""
+	}
+	return name[next:]
+}
+
+// pendingChanges collects the index keys touched by informer events until the next update
+type pendingChanges struct {
+	sync.Mutex
+	keys map[string]struct{}
+	full bool
+	wake chan struct{}
+}
+
+func newPendingChanges() *pendingChanges {
+	return &pendingChanges{keys: make(map[string]struct{}), wake: make(chan struct{}, 1)}
+}
+
+// invalidate schedules the answers derived from the given index keys to be recomputed,
+// a nil slice schedules a full rebuild
+func (gw *Gateway) invalidate(keys []string) {
+	p := gw.pending
+	p.Lock()
+	if keys == nil {
+		p.full = true
+	}
+	for _, key := range keys {
+		p.keys[strings.ToLower(key)] = struct{}{}
+	}
+	p.Unlock()
+
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// runSnapshots applies pending changes to the snapshot until the context is cancelled
+func (gw *Gateway) runSnapshots(ctx context.Context) {
+	ticker := time.NewTicker(snapshotResync)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			gw.invalidate(nil)
+		case <-gw.pending.wake:
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(snapshotDelay):
+			}
+
+			// changes received before the caches are synced are covered by the initial full build
+			if !gw.hasSynced() {
+				continue
+			}
+
+			p := gw.pending
+			p.Lock()
+			keys, full := p.keys, p.full
+			p.keys, p.full = make(map[string]struct{}), false
+			p.Unlock()
+
+			if full || gw.snapshot.Load() == nil {
+				gw.buildSnapshot()
+				continue
+			}
+			gw.updateSnapshot(keys)
+		}
+	}
+}
+
+// buildSnapshot computes the answers for every hostname known to the informers
+func (gw *Gateway) buildSnapshot() {
+	start := time.Now()
+
+	fqdns := make(map[string]struct{})
+	for _, resource := range orderedResources {
+		// the gauges of every zone of this instance are set, so that other instances keep theirs
+		indexed := make(map[string]int, len(gw.Zones))
+		for _, key := range resource.listKeys() {
+			zones := make(map[string]struct{})
+			for _, fqdn := range gw.candidates(strings.ToLower(key)) {
+				fqdns[fqdn] = struct{}{}
+				zones[plugin.Zones(gw.Zones).Matches(fqdn)] = struct{}{}
+			}
+			for zone := range zones {
+				indexed[zone]++
+			}
+		}
+		for _, zone := range gw.Zones {
+			indexedHostnames.WithLabelValues(zone, resource.name).Set(float64(indexed[zone]))
+		}
+	}
+
+	answers := make(map[string]*answer, len(fqdns))
+	nonTerminals := make(map[string]nonTerminal)
+	for fqdn := range fqdns {
+		if ans := gw.computeAnswer(fqdn); ans != nil {
+			answers[fqdn] = ans
+			gw.countParents(nonTerminals, fqdn, ans, 1)
+		}
+	}
+	nameservers := gw.nameservers()
+	glues := make(map[string]glue, len(nameservers))
+	for fqdn, zone := range nameservers {
+		glues[fqdn] = gw.computeGlue(fqdn, zone)
+	}
+	gw.storeSnapshot(&snapshot{answers: answers, nonTerminals: nonTerminals, glue: glues})
+	gw.conflicts.prune(fqdns)
+	gw.filtered.prune(fqdns)
+
+	log.Debugf("Built snapshot of %d hostnames in %s", len(answers), time.Since(start))
+}
+
+// updateSnapshot recomputes the answers derived from the given index keys and swaps in a new table
+func (gw *Gateway) updateSnapshot(keys map[string]struct{}) {
+	if len(keys) == 0 {
+		return
+	}
+
+	snap := gw.snapshot.Load()
+	answers, nonTerminals, glues := maps.Clone(snap.answers), maps.Clone(snap.nonTerminals), maps.Clone(snap.glue)
+	nameservers := gw.nameservers()
+	for key := range keys {
+		for _, fqdn := range gw.candidates(key) {
+			if zone, ok := nameservers[fqdn]; ok {
+				glues[fqdn] = gw.computeGlue(fqdn, zone)
+			}
+			if old, ok := answers[fqdn]; ok {
+				gw.countParents(nonTerminals, fqdn, old, -1)
+			}
+			if ans := gw.computeAnswer(fqdn); ans != nil {
+				answers[fqdn] = ans
+				gw.countParents(nonTerminals, fqdn, ans, 1)
+			} else {
+				delete(answers, fqdn)
+			}
+		}
+	}
+	gw.storeSnapshot(&snapshot{answers: answers, nonTerminals: nonTerminals, glue: glues})
+
+	log.Debugf("Updated %d index keys in snapshot", len(keys))
+}
+
+// storeSnapshot swaps in a new snapshot and signals the change to the publisher
+func (gw *Gateway) storeSnapshot(snap *snapshot) {
+	gw.snapshot.Store(snap)
+	select {
+	case gw.snapshotChanged <- struct{}{}:
+	default:
+	}
+}
+
+// candidates returns the FQDNs whose lookups include an index key: the key itself if it falls in
+// one of the zones, and the key appended to every zone for names indexed without a zone
+func (gw *Gateway) candidates(key string) (fqdns []string) {
+	if fqdn := key + "."; plugin.Zones(gw.Zones).Matches(fqdn) != "" {
+		fqdns = append(fqdns, fqdn)
+	}
+	for _, zone := range gw.Zones {
+		if zone == "." {
+			continue
+		}
+		fqdn := key + "." + zone
+		// the zoneless part of a name is relative to the most specific zone it falls in
+		if plugin.Zones(gw.Zones).Matches(fqdn) == zone && fqdn != zone {
+			fqdns = append(fqdns, fqdn)
+		}
+	}
+	return fqdns
+}
+
+// answerFor returns the answer for a query, from the snapshot when one has been built. Internal
+// queries get the answer of the internal view.
+func (gw *Gateway) answerFor(qname string, zc *zoneConfig, internal bool) *answer {
+	var ans *answer
+	if snap := gw.snapshot.Load(); snap != nil {
+		ans = snap.answers[strings.ToLower(qname)]
+	} else {
+		ans = gw.computeAnswer(qname)
+	}
+
+	if ans != nil && internal && ans.internal != nil {
+		ans = ans.internal
+	}
+	// a name may only have records in one of the views
+	if ans != nil && ans.empty() {
+		return nil
+	}
+
+	if ans != nil && (zc.merge && len(zc.weights) > 0 || ans.weighted) {
+		ans = zc.newAnswer(zc.orderByWeight(ans.winners), ans.winners)
+	}
+	return ans
+}
+
+// nameservers returns the zone of the apex and secondary nameserver FQDNs of every zone
+func (gw *Gateway) nameservers() map[string]string {
+	names := make(map[string]string)
+	for _, zone := range gw.Zones {
+		zc := gw.configFor(zone)
+		names[strings.ToLower(dnsutil.Join(zc.apex, zone))] = zone
+		if zc.secondNS != "" {
+			names[strings.ToLower(dnsutil.Join(zc.secondNS, zone))] = zone
+		}
+	}
+	return names
+}
+
+// computeGlue looks up the addresses of a nameserver FQDN of a zone
+func (gw *Gateway) computeGlue(fqdn, zone string) glue {
+	ipv4, ipv6 := gw.nameserverAddrs(gw.configFor(zone), zone, stripDomain(fqdn, zone))
+	return glue{ipv4: ipv4, ipv6: ipv6}
+}
+
+// glueFor returns the addresses of a nameserver name of a zone, from the snapshot when one has been built
+func (gw *Gateway) glueFor(zc *zoneConfig, zone, name string) (ipv4, ipv6 []netip.Addr) {
+	snap := gw.snapshot.Load()
+	if snap == nil {
+		return gw.nameserverAddrs(zc, zone, name)
+	}
+	g := snap.glue[strings.ToLower(dnsutil.Join(name, zone))]
+	return g.ipv4, g.ipv6
+}
+
+// isNonTerminal returns true if a name has no records of its own in a view but names below it do
+func (gw *Gateway) isNonTerminal(qname string, internal bool) bool {
+	snap := gw.snapshot.Load()
+	if snap == nil {
+		return gw.computeNonTerminal(qname)
+	}
+	count := snap.nonTerminals[strings.ToLower(qname)]
+	if internal {
+		return count.internal > 0
+	}
+	return count.regular > 0
+}
+
+// computeNonTerminal looks for an indexed name below a name, until the snapshot is built. An empty
+// non-terminal mistaken for NXDOMAIN would hide every name below it from resolvers. Only the index
+// keys are checked, answers aren't computed, so a name whose children are all filtered or lost to
+// conflicts gets NODATA until then.
+func (gw *Gateway) computeNonTerminal(qname string) bool {
+	zones := plugin.Zones(gw.Zones)
+	zone, suffix := zones.Matches(qname), "."+strings.ToLower(qname)
+	for _, resource := range orderedResources {
+		for _, key := range resource.listKeys() {
+			for _, fqdn := range gw.candidates(strings.ToLower(key)) {
+				// names in a more specific zone don't make their parents exist, as in the snapshot
+				if strings.HasSuffix(fqdn, suffix) && zones.Matches(fqdn) == zone {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// computeAnswer walks the informer indexes for a name, returning nil if nothing claims it
+func (gw *Gateway) computeAnswer(qname string) *answer {
+	zone := plugin.Zones(gw.Zones).Matches(qname)
+	if zone == "" {
+		return nil
+	}
+	zc := gw.configFor(zone)
+
+	var results []lookupResult
+	for _, resource := range zc.Resources {
+		results = append(results, resource.find(indexKeys(qname, zone))...)
+	}
+	results = gw.filterAddrs(zc, zone, qname, results)
+	results = gw.clusterPolicy.pick(gw.healthyResults(gw.servedResults(results)), gw.clusterHealthy)
+
+	// the conflict policy only breaks ties between the objects of the preferred priority
+	winners := gw.resolveConflicts(stripClosingDot(qname), preferred(results), zc.merge)
+	ordered := zc.orderByWeight(winners)
+
+	ans := zc.newAnswer(ordered, winners)
+	if len(gw.internalNets) > 0 {
+		ans.internal = zc.newAnswer(internalized(ordered), internalized(winners))
+	}
+	if ans.empty() && (ans.internal == nil || ans.internal.empty()) {
+		return nil
+	}
+	return ans
+}
+
+// newAnswer flattens the addresses of the ordered results, dropping duplicates. A hostname
+// target is published as a CNAME, unless other objects contribute addresses.
+func (zc *zoneConfig) newAnswer(ordered, winners []lookupResult) *answer {
+	ans := &answer{ttl: zc.answerTTL(ordered), winners: winners, weighted: weighted(winners)}
+
+	seen := make(map[netip.Addr]struct{})
+	for _, result := range ordered {
+		for _, addr := range result.addrs {
+			if _, ok := seen[addr]; ok {
+				continue
+			}
+			seen[addr] = struct{}{}
+			if region := result.addrRegion(addr); region != "" {
+				if ans.regions == nil {
+					ans.regions = make(map[netip.Addr]string)
+				}
+				ans.regions[addr] = region
+			}
+			if addr.Is4() {
+				ans.ipv4 = append(ans.ipv4, addr)
+			}
+			if addr.Is6() {
+				ans.ipv6 = append(ans.ipv6, addr)
+			}
+		}
+	}
+
+	if len(ans.ipv4) == 0 && len(ans.ipv6) == 0 {
+		for _, result := range ordered {
+			if result.cname != "" {
+				ans.cname = result.cname
+				break
+			}
+		}
+	}
+	return ans
+}
+
+// indexKeys computes the keys to look up in the informer indexes, which can also hold
+// names like `name.namespace` without the zone
+func indexKeys(qname, zone string) []string {
+	zonelessQuery := stripDomain(qname, zone)
+	strippedQName := stripClosingDot(qname)
+	if len(zonelessQuery) != 0 && zonelessQuery != strippedQName {
+		return []string{strippedQName, zonelessQuery}
+	}
+	return []string{strippedQName}
+}
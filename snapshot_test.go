@@ -0,0 +1,266 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	gatewayapi_v1 "sigs.k8s.io/gateway-api/apis/v1"
+)
+
+func TestSnapshot(t *testing.T) {
+
+	ctrl := newSyncedController()
+
+	gw := newGateway()
+	gw.Zones = []string{"example.com."}
+	gw.Next = test.NextHandler(dns.RcodeSuccess, nil)
+	gw.ExternalAddrFunc = gw.SelfAddress
+	gw.Controller = ctrl
+	setupLookupFuncs()
+	gw.buildSnapshot()
+
+	ctx := context.TODO()
+	for i, tc := range tests {
+		r := tc.Msg()
+		w := dnstest.NewRecorder(&test.ResponseWriter{})
+
+		_, err := gw.ServeDNS(ctx, w, r)
+		if err != tc.Error {
+			t.Errorf("Test %d expected no error, got %v", i, err)
+			return
+		}
+		if tc.Error != nil {
+			continue
+		}
+
+		if err = test.SortAndCheck(w.Msg, tc); err != nil {
+			t.Errorf("Test %d failed with error: %v", i, err)
+		}
+	}
+}
+
+func TestSnapshotUpdate(t *testing.T) {
+
+	ctrl := newSyncedController()
+
+	gw := newGateway()
+	gw.Zones = []string{"example.com."}
+	gw.Controller = ctrl
+	setupLookupFuncs()
+	gw.buildSnapshot()
+
+	if ans := gw.answerFor("new.example.com.", &gw.zoneConfig, false); ans != nil {
+		t.Fatalf("Expected no answer before the object is added, got %v", ans.ipv4)
+	}
+
+	testIngressIndexes["new.example.com"] = []netip.Addr{netip.MustParseAddr("192.0.0.10")}
+	gw.updateSnapshot(map[string]struct{}{"new.example.com": {}})
+	if ans := gw.answerFor("NEW.example.com.", &gw.zoneConfig, false); ans == nil || len(ans.ipv4) != 1 {
+		t.Errorf("Expected an answer once the object is added, got %v", ans)
+	}
+
+	delete(testIngressIndexes, "new.example.com")
+	gw.updateSnapshot(map[string]struct{}{"new.example.com": {}})
+	if ans := gw.answerFor("new.example.com.", &gw.zoneConfig, false); ans != nil {
+		t.Errorf("Expected no answer once the object is deleted, got %v", ans.ipv4)
+	}
+
+	// unrelated names are carried over to the new table
+	if ans := gw.answerFor("domain.example.com.", &gw.zoneConfig, false); ans == nil {
+		t.Errorf("Expected the answer of an unchanged name to be kept")
+	}
+
+	// glue is served from the snapshot until the nameserver name is invalidated
+	nameserver := testServiceIndexes["dns1.kube-system"]
+	t.Cleanup(func() { testServiceIndexes["dns1.kube-system"] = nameserver })
+	testServiceIndexes["dns1.kube-system"] = []netip.Addr{netip.MustParseAddr("192.0.1.54")}
+	if ipv4, _ := gw.glueFor(&gw.zoneConfig, "example.com.", gw.apex); len(ipv4) != 1 || ipv4[0] != nameserver[0] {
+		t.Errorf("Expected the glue of the snapshot, got %v", ipv4)
+	}
+	gw.updateSnapshot(map[string]struct{}{"dns1.kube-system": {}})
+	if ipv4, _ := gw.glueFor(&gw.zoneConfig, "example.com.", gw.apex); len(ipv4) != 1 || ipv4[0].String() != "192.0.1.54" {
+		t.Errorf("Expected the glue to be updated, got %v", ipv4)
+	}
+}
+
+func TestSnapshotNonTerminals(t *testing.T) {
+	gw := newGateway()
+	gw.Zones = []string{"example.com."}
+	gw.Next = test.NextHandler(dns.RcodeSuccess, nil)
+	gw.Controller = newSyncedController()
+	setupLookupFuncs()
+
+	testIngressIndexes["app.team.v6.example.com"] = []netip.Addr{netip.MustParseAddr("fd12:3456:789a:1::1")}
+	defer delete(testIngressIndexes, "app.team.v6.example.com")
+
+	query := func(name string, qtype uint16) int {
+		r := new(dns.Msg)
+		r.SetQuestion(name, qtype)
+		w := dnstest.NewRecorder(&test.ResponseWriter{})
+		gw.ServeDNS(context.TODO(), w, r)
+		return w.Msg.Rcode
+	}
+
+	// before the snapshot is built, only the index keys are checked, without computing answers
+	gw.denyCIDRs = []netip.Prefix{netip.MustParsePrefix("fd12::/16")}
+	if rcode := query("team.v6.example.com.", dns.TypeA); rcode != dns.RcodeSuccess {
+		t.Errorf("Expected NODATA for a parent of an indexed name, got %s", dns.RcodeToString[rcode])
+	}
+	if len(gw.filtered.seen) != 0 {
+		t.Errorf("Expected no answers to be computed for non-terminals, got filtered %v", gw.filtered.seen)
+	}
+	gw.denyCIDRs = nil
+	gw.buildSnapshot()
+
+	tests := []struct {
+		qname    string
+		qtype    uint16
+		expected int
+	}{
+		// ns1.example.com holds svc1.ns1.example.com
+		{"ns1.example.com.", dns.TypeA, dns.RcodeSuccess},
+		{"NS1.example.com.", dns.TypeTXT, dns.RcodeSuccess},
+		{"team.v6.example.com.", dns.TypeAAAA, dns.RcodeSuccess},
+		{"v6.example.com.", dns.TypeA, dns.RcodeSuccess},
+		// a name with only IPv6 addresses exists for A queries
+		{"app.team.v6.example.com.", dns.TypeA, dns.RcodeSuccess},
+		{"ns2.example.com.", dns.TypeA, dns.RcodeNameError},
+		{"x.ns1.example.com.", dns.TypeA, dns.RcodeNameError},
+	}
+	for i, tc := range tests {
+		if rcode := query(tc.qname, tc.qtype); rcode != tc.expected {
+			t.Errorf("Test %d: expected %s for %s, got %s", i, dns.RcodeToString[tc.expected], tc.qname, dns.RcodeToString[rcode])
+		}
+	}
+
+	// parents are dropped along with the last name below them
+	delete(testIngressIndexes, "app.team.v6.example.com")
+	gw.updateSnapshot(map[string]struct{}{"app.team.v6.example.com": {}})
+	if rcode := query("v6.example.com.", dns.TypeA); rcode != dns.RcodeNameError {
+		t.Errorf("Expected NXDOMAIN once the name below is deleted, got %s", dns.RcodeToString[rcode])
+	}
+}
+
+func TestSnapshotCandidates(t *testing.T) {
+	gw := newGateway()
+	gw.Zones = []string{"example.com.", "internal.example.com."}
+
+	tests := []struct {
+		key      string
+		expected []string
+	}{
+		{"svc1.ns1", []string{"svc1.ns1.example.com.", "svc1.ns1.internal.example.com."}},
+		{"domain.example.com", []string{"domain.example.com.", "domain.example.com.example.com.", "domain.example.com.internal.example.com."}},
+		// names falling in a more specific zone are relative to that zone
+		{"svc1.internal", []string{"svc1.internal.internal.example.com."}},
+		{"example.org", []string{"example.org.example.com.", "example.org.internal.example.com."}},
+	}
+
+	for i, test := range tests {
+		found := gw.candidates(test.key)
+		if fmt.Sprint(found) != fmt.Sprint(test.expected) {
+			t.Errorf("Test %d: expected candidates %v, got %v", i, test.expected, found)
+		}
+	}
+}
+
+// benchmarkRoutes is the number of HTTPRoutes in the benchmarks, matching a large cluster
+const benchmarkRoutes = 20000
+
+func BenchmarkServeDNS(b *testing.B) {
+	gw := newGateway()
+	gw.Zones = []string{"example.com."}
+	gw.Next = test.NextHandler(dns.RcodeSuccess, nil)
+	gw.Controller = newSyncedController()
+	setupBenchmarkRoutes(b, benchmarkRoutes)
+
+	r := new(dns.Msg)
+	r.SetQuestion(fmt.Sprintf("route-%d.example.com.", benchmarkRoutes/2), dns.TypeA)
+	ctx := context.TODO()
+
+	b.Run("index", func(b *testing.B) {
+		gw.snapshot.Store(nil)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			gw.ServeDNS(ctx, &test.ResponseWriter{}, r)
+		}
+	})
+
+	b.Run("snapshot", func(b *testing.B) {
+		gw.buildSnapshot()
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			gw.ServeDNS(ctx, &test.ResponseWriter{}, r)
+		}
+	})
+}
+
+func BenchmarkSnapshotBuild(b *testing.B) {
+	gw := newGateway()
+	gw.Zones = []string{"example.com."}
+	gw.Controller = newSyncedController()
+	setupBenchmarkRoutes(b, benchmarkRoutes)
+
+	b.Run("full", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			gw.buildSnapshot()
+		}
+	})
+
+	b.Run("incremental", func(b *testing.B) {
+		gw.buildSnapshot()
+		keys := map[string]struct{}{"route-1.example.com": {}}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			gw.updateSnapshot(keys)
+		}
+	})
+}
+
+// setupBenchmarkRoutes backs the HTTPRoute lookups with informer indexes holding the given number
+// of routes, all attached to a single Gateway
+func setupBenchmarkRoutes(b *testing.B, count int) {
+	b.Cleanup(func() {
+		clearLookupFuncs()
+		setupLookupFuncs()
+	})
+	clearLookupFuncs()
+
+	gateways := cache.NewSharedIndexInformer(&cache.ListWatch{}, &gatewayapi_v1.Gateway{}, defaultResyncPeriod,
+		cache.Indexers{gatewayUniqueIndex: gatewayIndexFunc})
+	routes := cache.NewSharedIndexInformer(&cache.ListWatch{}, &gatewayapi_v1.HTTPRoute{}, defaultResyncPeriod,
+		cache.Indexers{httpRouteHostnameIndex: httpRouteHostnameIndexFunc})
+
+	ipType := gatewayapi_v1.IPAddressType
+	_ = gateways.GetIndexer().Add(&gatewayapi_v1.Gateway{
+		ObjectMeta: meta.ObjectMeta{Name: "gw", Namespace: "ns1"},
+		Status: gatewayapi_v1.GatewayStatus{
+			Addresses: []gatewayapi_v1.GatewayStatusAddress{{Type: &ipType, Value: "192.0.2.1"}},
+		},
+	})
+	for i := 0; i < count; i++ {
+		_ = routes.GetIndexer().Add(&gatewayapi_v1.HTTPRoute{
+			ObjectMeta: meta.ObjectMeta{Name: fmt.Sprintf("route-%d", i), Namespace: "ns1"},
+			Spec: gatewayapi_v1.HTTPRouteSpec{
+				CommonRouteSpec: gatewayapi_v1.CommonRouteSpec{
+					ParentRefs: []gatewayapi_v1.ParentReference{{Name: "gw"}},
+				},
+				Hostnames: []gatewayapi_v1.Hostname{gatewayapi_v1.Hostname(fmt.Sprintf("route-%d.example.com", i))},
+			},
+		})
+	}
+
+	resource := lookupResource("HTTPRoute")
+	resource.lookup = lookupHttpRouteIndex(routes, gateways, nil)
+	resource.keys = indexValues(routes, httpRouteHostnameIndex)
+}
@@ -0,0 +1,141 @@
+package gateway
+
+import (
+	"time"
+
+	nginx_v1 "github.com/nginxinc/kubernetes-ingress/pkg/apis/configuration/v1"
+	core "k8s.io/api/core/v1"
+	discovery "k8s.io/api/discovery/v1"
+	networking "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/cache"
+	gatewayapi_v1 "sigs.k8s.io/gateway-api/apis/v1"
+	gatewayapi_v1alpha2 "sigs.k8s.io/gateway-api/apis/v1alpha2"
+)
+
+// keptAnnotations are the only annotations read by the plugin, all others are dropped from the cache
+var keptAnnotations = []string{
+	hostnameAnnotationKey,
+	externalDnsHostnameAnnotationKey,
+	ttlAnnotationKey,
+	externalDnsTtlAnnotationKey,
+	targetAnnotationKey,
+	externalDnsTargetAnnotationKey,
+	publishedAnnotationKey,
+	internalTargetAnnotationKey,
+	weightAnnotationKey,
+	priorityAnnotationKey,
+	regionKey,
+}
+
+// newStrippedInformer builds an informer that only caches the fields used by lookups.
+// Metadata-only watches can't be used since every kind needs some of its spec or status,
+// so objects are stripped by a transform before they are stored instead.
+func newStrippedInformer(lw cache.ListerWatcher, exampleObject runtime.Object, resync time.Duration, indexers cache.Indexers) cache.SharedIndexInformer {
+	informer := cache.NewSharedIndexInformer(lw, exampleObject, resync, indexers)
+	if err := informer.SetTransform(stripObject); err != nil {
+		log.Warningf("Failed to set transform for %T informer: %s", exampleObject, err)
+	}
+	return informer
+}
+
+// stripObject drops the fields that make up most of the size of an object but aren't needed
+// to answer queries, like managed fields, backends and route rules
+func stripObject(obj interface{}) (interface{}, error) {
+	switch obj := obj.(type) {
+	case *core.Service:
+		stripMeta(&obj.ObjectMeta)
+		obj.Spec = core.ServiceSpec{
+			Type:        obj.Spec.Type,
+			ClusterIPs:  obj.Spec.ClusterIPs,
+			IPFamilies:  obj.Spec.IPFamilies,
+			ExternalIPs: obj.Spec.ExternalIPs,
+		}
+		obj.Status = core.ServiceStatus{LoadBalancer: obj.Status.LoadBalancer}
+	case *networking.Ingress:
+		stripMeta(&obj.ObjectMeta)
+		rules := make([]networking.IngressRule, 0, len(obj.Spec.Rules))
+		for _, rule := range obj.Spec.Rules {
+			rules = append(rules, networking.IngressRule{Host: rule.Host})
+		}
+		obj.Spec = networking.IngressSpec{Rules: rules}
+	case *gatewayapi_v1.Gateway:
+		stripMeta(&obj.ObjectMeta)
+	case *gatewayapi_v1.HTTPRoute:
+		stripMeta(&obj.ObjectMeta)
+		// backends are kept for health checks
+		rules := make([]gatewayapi_v1.HTTPRouteRule, 0, len(obj.Spec.Rules))
+		for _, rule := range obj.Spec.Rules {
+			refs := make([]gatewayapi_v1.HTTPBackendRef, 0, len(rule.BackendRefs))
+			for _, ref := range rule.BackendRefs {
+				refs = append(refs, gatewayapi_v1.HTTPBackendRef{BackendRef: ref.BackendRef})
+			}
+			rules = append(rules, gatewayapi_v1.HTTPRouteRule{BackendRefs: refs})
+		}
+		obj.Spec = gatewayapi_v1.HTTPRouteSpec{
+			CommonRouteSpec: obj.Spec.CommonRouteSpec,
+			Hostnames:       obj.Spec.Hostnames,
+			Rules:           rules,
+		}
+		obj.Status = gatewayapi_v1.HTTPRouteStatus{}
+	case *gatewayapi_v1alpha2.TLSRoute:
+		stripMeta(&obj.ObjectMeta)
+		rules := make([]gatewayapi_v1alpha2.TLSRouteRule, 0, len(obj.Spec.Rules))
+		for _, rule := range obj.Spec.Rules {
+			rules = append(rules, gatewayapi_v1alpha2.TLSRouteRule{BackendRefs: rule.BackendRefs})
+		}
+		obj.Spec = gatewayapi_v1alpha2.TLSRouteSpec{
+			CommonRouteSpec: obj.Spec.CommonRouteSpec,
+			Hostnames:       obj.Spec.Hostnames,
+			Rules:           rules,
+		}
+		obj.Status = gatewayapi_v1alpha2.TLSRouteStatus{}
+	case *gatewayapi_v1alpha2.GRPCRoute:
+		stripMeta(&obj.ObjectMeta)
+		rules := make([]gatewayapi_v1alpha2.GRPCRouteRule, 0, len(obj.Spec.Rules))
+		for _, rule := range obj.Spec.Rules {
+			refs := make([]gatewayapi_v1alpha2.GRPCBackendRef, 0, len(rule.BackendRefs))
+			for _, ref := range rule.BackendRefs {
+				refs = append(refs, gatewayapi_v1alpha2.GRPCBackendRef{BackendRef: ref.BackendRef})
+			}
+			rules = append(rules, gatewayapi_v1alpha2.GRPCRouteRule{BackendRefs: refs})
+		}
+		obj.Spec = gatewayapi_v1alpha2.GRPCRouteSpec{
+			CommonRouteSpec: obj.Spec.CommonRouteSpec,
+			Hostnames:       obj.Spec.Hostnames,
+			Rules:           rules,
+		}
+		obj.Status = gatewayapi_v1alpha2.GRPCRouteStatus{}
+	case *discovery.EndpointSlice:
+		stripEndpointSlice(obj)
+	case *nginx_v1.VirtualServer:
+		stripMeta(&obj.ObjectMeta)
+		obj.Spec = nginx_v1.VirtualServerSpec{Host: obj.Spec.Host}
+	}
+	return obj, nil
+}
+
+// stripMeta keeps the identity of an object, which is needed for events and conflict resolution
+func stripMeta(m *metav1.ObjectMeta) {
+	m.ManagedFields = nil
+	m.OwnerReferences = nil
+	m.Finalizers = nil
+	// only the topology label is read
+	if region, ok := m.Labels[regionKey]; ok {
+		m.Labels = map[string]string{regionKey: region}
+	} else {
+		m.Labels = nil
+	}
+
+	var annotations map[string]string
+	for _, key := range keptAnnotations {
+		if value, ok := m.Annotations[key]; ok {
+			if annotations == nil {
+				annotations = make(map[string]string)
+			}
+			annotations[key] = value
+		}
+	}
+	m.Annotations = annotations
+}
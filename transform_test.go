@@ -0,0 +1,196 @@
+package gateway
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+
+	core "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/cache"
+	gatewayapi_v1 "sigs.k8s.io/gateway-api/apis/v1"
+)
+
+func TestStripObject(t *testing.T) {
+	service := benchmarkService(1)
+	expectedKeys, _ := serviceHostnameIndexFunc(service)
+
+	obj, err := stripObject(service)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	stripped := obj.(*core.Service)
+
+	if keys, _ := serviceHostnameIndexFunc(stripped); fmt.Sprint(keys) != fmt.Sprint(expectedKeys) {
+		t.Errorf("Expected index keys %v, got %v", expectedKeys, keys)
+	}
+	if len(stripped.ManagedFields) != 0 || len(stripped.Spec.Ports) != 0 || len(stripped.Labels) != 0 {
+		t.Errorf("Expected managed fields, ports and labels to be dropped, got %+v", stripped)
+	}
+	if len(stripped.Annotations) != 1 || stripped.Annotations[hostnameAnnotationKey] == "" {
+		t.Errorf("Expected only the hostname annotation to be kept, got %v", stripped.Annotations)
+	}
+	if len(stripped.Status.LoadBalancer.Ingress) != 1 || stripped.Spec.Type != core.ServiceTypeLoadBalancer || len(stripped.Spec.IPFamilies) != 2 {
+		t.Errorf("Expected the load balancer status, type and IP families to be kept, got %+v", stripped)
+	}
+
+	route := benchmarkHTTPRoute(1)
+	obj, _ = stripObject(route)
+	if stripped := obj.(*gatewayapi_v1.HTTPRoute); len(stripped.Spec.Hostnames) != 1 || len(stripped.Spec.ParentRefs) != 1 {
+		t.Errorf("Expected hostnames and parent refs to be kept, got %+v", stripped.Spec)
+	} else if rules := stripped.Spec.Rules; len(rules) != 1 || len(rules[0].Matches) != 0 || len(rules[0].BackendRefs) != 1 {
+		t.Errorf("Expected only the backend refs of the rules to be kept, got %+v", rules)
+	}
+
+	ingress := benchmarkIngress(1)
+	obj, _ = stripObject(ingress)
+	if stripped := obj.(*networking.Ingress); len(stripped.Spec.Rules) != 1 || stripped.Spec.Rules[0].HTTP != nil || stripped.Spec.Rules[0].Host == "" {
+		t.Errorf("Expected only the rule hosts to be kept, got %+v", stripped.Spec)
+	}
+
+	// objects of unknown types are passed through
+	if obj, _ := stripObject("unknown"); obj != "unknown" {
+		t.Errorf("Expected unknown objects to be returned as is, got %v", obj)
+	}
+}
+
+// benchmarkObjects is the number of objects of each kind in the memory benchmark
+const benchmarkObjects = 20000
+
+// BenchmarkInformerMemory reports the heap held by informer caches of a synthetic cluster
+func BenchmarkInformerMemory(b *testing.B) {
+	for _, strip := range []bool{false, true} {
+		name := "full"
+		if strip {
+			name = "stripped"
+		}
+
+		b.Run(name, func(b *testing.B) {
+			var heap uint64
+			for i := 0; i < b.N; i++ {
+				var before, after runtime.MemStats
+				runtime.GC()
+				runtime.ReadMemStats(&before)
+
+				stores := []cache.Indexer{
+					cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{serviceHostnameIndex: serviceHostnameIndexFunc}),
+					cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{ingressHostnameIndex: ingressHostnameIndexFunc}),
+					cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{httpRouteHostnameIndex: httpRouteHostnameIndexFunc}),
+				}
+				for j := 0; j < benchmarkObjects; j++ {
+					for k, obj := range []interface{}{benchmarkService(j), benchmarkIngress(j), benchmarkHTTPRoute(j)} {
+						if strip {
+							obj, _ = stripObject(obj)
+						}
+						_ = stores[k].Add(obj)
+					}
+				}
+
+				runtime.GC()
+				runtime.ReadMemStats(&after)
+				heap += after.HeapAlloc - before.HeapAlloc
+				runtime.KeepAlive(stores)
+			}
+			b.ReportMetric(float64(heap)/float64(b.N)/(1<<20), "MiB")
+		})
+	}
+}
+
+// benchmarkMeta returns metadata as found on objects applied with kubectl
+func benchmarkMeta(kind string, i int) meta.ObjectMeta {
+	name := fmt.Sprintf("%s-%d", strings.ToLower(kind), i)
+	return meta.ObjectMeta{
+		Name:      name,
+		Namespace: "ns1",
+		Labels:    map[string]string{"app.kubernetes.io/name": name, "app.kubernetes.io/part-of": "benchmark"},
+		Annotations: map[string]string{
+			hostnameAnnotationKey: name + ".example.com",
+			"kubectl.kubernetes.io/last-applied-configuration": fmt.Sprintf(`{"apiVersion":"v1","kind":"%s","metadata":{"name":"%s","namespace":"ns1"},"spec":{%s}}`,
+				kind, name, strings.Repeat(`"padding":"value",`, 20)),
+		},
+		ManagedFields: []meta.ManagedFieldsEntry{
+			{Manager: "kubectl-client-side-apply", Operation: meta.ManagedFieldsOperationUpdate, FieldsType: "FieldsV1",
+				FieldsV1: &meta.FieldsV1{Raw: []byte(strings.Repeat(`{"f:metadata":{"f:annotations":{}}}`, 10))}},
+		},
+	}
+}
+
+func benchmarkService(i int) *core.Service {
+	return &core.Service{
+		ObjectMeta: benchmarkMeta("Service", i),
+		Spec: core.ServiceSpec{
+			Type:       core.ServiceTypeLoadBalancer,
+			IPFamilies: []core.IPFamily{core.IPv4Protocol, core.IPv6Protocol},
+			Selector:   map[string]string{"app": fmt.Sprintf("app-%d", i)},
+			Ports: []core.ServicePort{
+				{Name: "http", Port: 80, TargetPort: intstr.FromString("http")},
+				{Name: "https", Port: 443, TargetPort: intstr.FromString("https")},
+			},
+		},
+		Status: core.ServiceStatus{
+			LoadBalancer: core.LoadBalancerStatus{Ingress: []core.LoadBalancerIngress{{IP: "192.0.2.1"}}},
+		},
+	}
+}
+
+func benchmarkIngress(i int) *networking.Ingress {
+	pathType := networking.PathTypePrefix
+	return &networking.Ingress{
+		ObjectMeta: benchmarkMeta("Ingress", i),
+		Spec: networking.IngressSpec{
+			Rules: []networking.IngressRule{{
+				Host: fmt.Sprintf("ingress-%d.example.com", i),
+				IngressRuleValue: networking.IngressRuleValue{HTTP: &networking.HTTPIngressRuleValue{
+					Paths: []networking.HTTPIngressPath{{
+						Path:     "/",
+						PathType: &pathType,
+						Backend: networking.IngressBackend{Service: &networking.IngressServiceBackend{
+							Name: fmt.Sprintf("service-%d", i), Port: networking.ServiceBackendPort{Number: 80},
+						}},
+					}},
+				}},
+			}},
+		},
+		Status: networking.IngressStatus{
+			LoadBalancer: networking.IngressLoadBalancerStatus{Ingress: []networking.IngressLoadBalancerIngress{{IP: "192.0.2.2"}}},
+		},
+	}
+}
+
+func benchmarkHTTPRoute(i int) *gatewayapi_v1.HTTPRoute {
+	port := gatewayapi_v1.PortNumber(80)
+	return &gatewayapi_v1.HTTPRoute{
+		ObjectMeta: benchmarkMeta("HTTPRoute", i),
+		Spec: gatewayapi_v1.HTTPRouteSpec{
+			CommonRouteSpec: gatewayapi_v1.CommonRouteSpec{
+				ParentRefs: []gatewayapi_v1.ParentReference{{Name: "gw"}},
+			},
+			Hostnames: []gatewayapi_v1.Hostname{gatewayapi_v1.Hostname(fmt.Sprintf("route-%d.example.com", i))},
+			Rules: []gatewayapi_v1.HTTPRouteRule{{
+				BackendRefs: []gatewayapi_v1.HTTPBackendRef{{
+					BackendRef: gatewayapi_v1.BackendRef{
+						BackendObjectReference: gatewayapi_v1.BackendObjectReference{
+							Name: gatewayapi_v1.ObjectName(fmt.Sprintf("service-%d", i)),
+							Port: &port,
+						},
+					},
+				}},
+			}},
+		},
+		Status: gatewayapi_v1.HTTPRouteStatus{
+			RouteStatus: gatewayapi_v1.RouteStatus{
+				Parents: []gatewayapi_v1.RouteParentStatus{{
+					ParentRef:      gatewayapi_v1.ParentReference{Name: "gw"},
+					ControllerName: "example.com/gateway-controller",
+					Conditions: []meta.Condition{
+						{Type: "Accepted", Status: meta.ConditionTrue, Reason: "Accepted", Message: "Route is accepted"},
+						{Type: "ResolvedRefs", Status: meta.ConditionTrue, Reason: "ResolvedRefs", Message: "All references resolved"},
+					},
+				}},
+			},
+		},
+	}
+}
@@ -0,0 +1,53 @@
+package gateway
+
+import (
+	"net/netip"
+
+	"github.com/coredns/coredns/request"
+)
+
+// isInternal returns true if the querier is in one of the networks served the internal view
+func (gw *Gateway) isInternal(state request.Request) bool {
+	if len(gw.internalNets) == 0 {
+		return false
+	}
+	addr, err := netip.ParseAddr(state.IP())
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range gw.internalNets {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// servedResults drops the internal addresses of the results when no internal view is configured,
+// so that objects only claim names with their regular addresses
+func (gw *Gateway) servedResults(results []lookupResult) []lookupResult {
+	if len(gw.internalNets) > 0 {
+		return results
+	}
+	for i := range results {
+		results[i].internal, results[i].internalCNAME = nil, ""
+	}
+	return results
+}
+
+// internalized returns the results as seen from the internal view, where the internal addresses
+// of an object replace the regular ones if it has any
+func internalized(results []lookupResult) []lookupResult {
+	if results == nil {
+		return nil
+	}
+	internal := make([]lookupResult, len(results))
+	for i, result := range results {
+		if len(result.internal) > 0 || result.internalCNAME != "" {
+			result.addrs, result.cname = result.internal, result.internalCNAME
+		}
+		internal[i] = result
+	}
+	return internal
+}
@@ -0,0 +1,123 @@
+package gateway
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestInternalView(t *testing.T) {
+	gw := newGateway()
+	gw.Zones = []string{"example.com."}
+	gw.Controller = newSyncedController()
+	gw.Next = test.NextHandler(dns.RcodeSuccess, nil)
+	// test.ResponseWriter queries from 10.240.0.1, test.ResponseWriter6 from fe80::42:ff:feca:4c65
+	gw.internalNets = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+
+	results := map[string]lookupResult{
+		"app.example.com": {
+			kind:     "Service",
+			addrs:    []netip.Addr{netip.MustParseAddr("198.51.100.1")},
+			internal: []netip.Addr{netip.MustParseAddr("10.96.0.1")},
+		},
+		"public.example.com": {
+			kind:  "Ingress",
+			addrs: []netip.Addr{netip.MustParseAddr("198.51.100.2")},
+		},
+		"private.example.com": {
+			kind:     "Service",
+			internal: []netip.Addr{netip.MustParseAddr("10.96.0.2")},
+		},
+	}
+	clearLookupFuncs()
+	lookupResource("Service").setLookup("", gw, func(keys []string) (found []lookupResult) {
+		for _, key := range keys {
+			if result, ok := results[key]; ok {
+				found = append(found, result)
+			}
+		}
+		return found
+	}, func() []string { return []string{"app.example.com", "public.example.com", "private.example.com"} })
+	gw.buildSnapshot()
+
+	tests := []struct {
+		qname    string
+		internal bool
+		rcode    int
+		expected string
+	}{
+		{"app.example.com.", true, dns.RcodeSuccess, "10.96.0.1"},
+		{"app.example.com.", false, dns.RcodeSuccess, "198.51.100.1"},
+		{"public.example.com.", true, dns.RcodeSuccess, "198.51.100.2"},
+		{"private.example.com.", true, dns.RcodeSuccess, "10.96.0.2"},
+		{"private.example.com.", false, dns.RcodeNameError, ""},
+	}
+
+	for i, tc := range tests {
+		var rw dns.ResponseWriter = &test.ResponseWriter6{}
+		if tc.internal {
+			rw = &test.ResponseWriter{}
+		}
+		w := dnstest.NewRecorder(rw)
+		r := new(dns.Msg)
+		r.SetQuestion(tc.qname, dns.TypeA)
+
+		if _, err := gw.ServeDNS(context.TODO(), w, r); err != nil {
+			t.Errorf("Test %d: expected no error, got %v", i, err)
+			continue
+		}
+		if w.Msg.Rcode != tc.rcode {
+			t.Errorf("Test %d: expected rcode %d, got %d", i, tc.rcode, w.Msg.Rcode)
+			continue
+		}
+		if tc.expected == "" {
+			continue
+		}
+		if len(w.Msg.Answer) != 1 || w.Msg.Answer[0].(*dns.A).A.String() != tc.expected {
+			t.Errorf("Test %d: expected %s, got %v", i, tc.expected, w.Msg.Answer)
+		}
+	}
+}
+
+func TestServiceInternalTarget(t *testing.T) {
+	tests := []struct {
+		annotations map[string]string
+		clusterIPs  []string
+		expected    []string
+		cname       string
+	}{
+		{nil, []string{"10.96.0.1", "fd00::1"}, []string{"10.96.0.1", "fd00::1"}, ""},
+		{nil, []string{"None"}, nil, ""},
+		{map[string]string{internalTargetAnnotationKey: "10.0.0.5"}, []string{"10.96.0.1"}, []string{"10.0.0.5"}, ""},
+		{map[string]string{internalTargetAnnotationKey: "internal-lb.example.net"}, []string{"10.96.0.1"}, nil, "internal-lb.example.net."},
+	}
+
+	for i, tc := range tests {
+		service := &core.Service{
+			ObjectMeta: meta.ObjectMeta{Name: "svc", Namespace: "ns", Annotations: tc.annotations},
+			Spec:       core.ServiceSpec{ClusterIPs: tc.clusterIPs},
+		}
+		addrs, cname := serviceInternalTarget(service)
+
+		var got []string
+		for _, addr := range addrs {
+			got = append(got, addr.String())
+		}
+		if len(got) != len(tc.expected) || cname != tc.cname {
+			t.Errorf("Test %d: expected %v %q, got %v %q", i, tc.expected, tc.cname, got, cname)
+			continue
+		}
+		for j := range got {
+			if got[j] != tc.expected[j] {
+				t.Errorf("Test %d: expected %v, got %v", i, tc.expected, got)
+				break
+			}
+		}
+	}
+}
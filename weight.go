@@ -0,0 +1,82 @@
+package gateway
+
+import (
+	"strconv"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	weightAnnotationKey   = "coredns.io/weight"
+	priorityAnnotationKey = "coredns.io/priority"
+)
+
+// objectWeight returns the weight requested by the weight annotation of an object
+func objectWeight(obj metav1.Object) (int, bool) {
+	return intAnnotation(obj, weightAnnotationKey)
+}
+
+// objectPriority returns the priority requested by the priority annotation of an object, 0 by default
+func objectPriority(obj metav1.Object) int {
+	priority, _ := intAnnotation(obj, priorityAnnotationKey)
+	return priority
+}
+
+func intAnnotation(obj metav1.Object, key string) (int, bool) {
+	value, exists := obj.GetAnnotations()[key]
+	if !exists {
+		return 0, false
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		log.Debugf("Ignoring invalid annotation %s=%s on %s/%s", key, value, obj.GetNamespace(), obj.GetName())
+		return 0, false
+	}
+	return n, true
+}
+
+func (r lookupResult) weight() (int, bool) {
+	if r.object == nil {
+		return 0, false
+	}
+	return objectWeight(r.object)
+}
+
+func (r lookupResult) priority() int {
+	if r.object == nil {
+		return 0
+	}
+	return objectPriority(r.object)
+}
+
+// weighted returns true if any of the results has its own weight, so that answers are shuffled on every query
+func weighted(results []lookupResult) bool {
+	for _, result := range results {
+		if _, ok := result.weight(); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// preferred keeps the results with the lowest priority value among those claiming the name, the
+// others are only published once none of them claims it anymore
+func preferred(results []lookupResult) []lookupResult {
+	best := -1
+	for _, result := range results {
+		if p := result.priority(); result.claims() && (best < 0 || p < best) {
+			best = p
+		}
+	}
+	if best < 0 {
+		return results
+	}
+
+	var kept []lookupResult
+	for _, result := range results {
+		if result.priority() == best {
+			kept = append(kept, result)
+		}
+	}
+	return kept
+}
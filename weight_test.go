@@ -0,0 +1,90 @@
+package gateway
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	networking "k8s.io/api/networking/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPreferred(t *testing.T) {
+	primary := testWeightedResult("primary", "192.0.2.1", map[string]string{priorityAnnotationKey: "0"})
+	backup := testWeightedResult("backup", "192.0.2.2", map[string]string{priorityAnnotationKey: "10"})
+	empty := testWeightedResult("empty", "", nil)
+
+	if kept := preferred([]lookupResult{backup, primary}); len(kept) != 1 || kept[0].object.GetName() != "primary" {
+		t.Errorf("Expected the primary to be kept, got %v", kept)
+	}
+	if kept := preferred([]lookupResult{backup, empty}); len(kept) != 1 || kept[0].object.GetName() != "backup" {
+		t.Errorf("Expected the backup to be kept without a claiming primary, got %v", kept)
+	}
+	invalid := testWeightedResult("invalid", "192.0.2.3", map[string]string{priorityAnnotationKey: "-1"})
+	if kept := preferred([]lookupResult{backup, invalid}); len(kept) != 1 || kept[0].object.GetName() != "invalid" {
+		t.Errorf("Expected an invalid priority to default to 0, got %v", kept)
+	}
+}
+
+func TestPriorityBeforeConflicts(t *testing.T) {
+	t.Cleanup(setupLookupFuncs)
+
+	gw := newGateway()
+	gw.Zones = []string{"example.com."}
+	gw.Controller = newSyncedController()
+	gw.conflict = conflictPolicy{mode: conflictOldest}
+
+	standby := testConflictResult("Ingress", "default", "standby", 3*time.Hour, "192.0.2.1")
+	older := testConflictResult("Ingress", "default", "older", 2*time.Hour, "192.0.2.2")
+	newer := testConflictResult("Ingress", "default", "newer", time.Hour, "192.0.2.3")
+	standby.object.SetAnnotations(map[string]string{priorityAnnotationKey: "10"})
+
+	clearLookupFuncs()
+	lookupResource("Ingress").setLookup("", gw, func([]string) []lookupResult {
+		return []lookupResult{standby, older, newer}
+	}, func() []string { return []string{"app"} })
+
+	// the oldest object loses to the ones with a lower priority, the oldest of which wins
+	ans := gw.computeAnswer("app.example.com.")
+	if ans == nil || len(ans.ipv4) != 1 || ans.ipv4[0].String() != "192.0.2.2" {
+		t.Errorf("Expected the oldest object of the preferred priority to win, got %v", ans)
+	}
+}
+
+func TestOrderByObjectWeight(t *testing.T) {
+	zc := &zoneConfig{}
+	stable := testWeightedResult("stable", "192.0.2.1", map[string]string{weightAnnotationKey: "9"})
+	canary := testWeightedResult("canary", "192.0.2.2", map[string]string{weightAnnotationKey: "1"})
+	drained := testWeightedResult("drained", "192.0.2.3", map[string]string{weightAnnotationKey: "0"})
+
+	const runs = 2000
+	first := make(map[string]int)
+	for i := 0; i < runs; i++ {
+		ordered := zc.orderByWeight([]lookupResult{canary, drained, stable})
+		if len(ordered) != 2 {
+			t.Fatalf("Expected the drained object to be dropped, got %v", ordered)
+		}
+		first[ordered[0].object.GetName()]++
+	}
+	// stable is expected first 90% of the time
+	if first["stable"] < runs*8/10 || first["canary"] < runs/20 {
+		t.Errorf("Expected stable first about 90%% of the time, got %v", first)
+	}
+
+	// without weights the order is kept
+	a, b := testWeightedResult("a", "192.0.2.1", nil), testWeightedResult("b", "192.0.2.2", nil)
+	if ordered := zc.orderByWeight([]lookupResult{b, a}); ordered[0].object.GetName() != "b" {
+		t.Errorf("Expected the order to be kept, got %v", ordered)
+	}
+}
+
+func testWeightedResult(name, addr string, annotations map[string]string) lookupResult {
+	result := lookupResult{
+		kind:   "Ingress",
+		object: &networking.Ingress{ObjectMeta: meta.ObjectMeta{Name: name, Namespace: "default", Annotations: annotations}},
+	}
+	if addr != "" {
+		result.addrs = []netip.Addr{netip.MustParseAddr(addr)}
+	}
+	return result
+}